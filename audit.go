@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AuditReportStep records the file types in a repo or dependency
+// at a stage of its pipeline, so the end of the run can report
+// what thinning removed. Each stage is kept in the state folder,
+// since a resumed run can't look at the folder before thinning.
+type AuditReportStep struct {
+	Name   string // The repo, or module@version of a dependency
+	Kind   string // One of the Audit kind consts
+	Folder string
+	Stage  string // One of the Audit stage consts
+	Print  bool   // Also print the totals
+}
+
+func (s AuditReportStep) Run(p StepParams) error {
+	file, rel, ok := folderStatePath(p.Cfg.Output, "audit", s.Folder)
+	if !ok {
+		return nil
+	}
+	totals, err := auditFolder(s.Folder)
+	if err != nil {
+		return err
+	}
+	// Keep the other stage.
+	report, err := readAuditReport(file)
+	if err != nil {
+		return err
+	}
+	if s.Print {
+		printAuditTotals(s.Folder, totals)
+	}
+	report.Name, report.Kind, report.Folder = s.Name, s.Kind, filepath.ToSlash(rel)
+	switch s.Stage {
+	case AuditBefore:
+		report.Before = &totals
+	case AuditAfter:
+		report.After = &totals
+	default:
+		return fmt.Errorf("unknown audit stage %v", s.Stage)
+	}
+	b, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+func (s AuditReportStep) StepId() string {
+	return "audit" + s.Stage + ":" + s.Folder
+}
+
+func (s AuditReportStep) StepInputs() interface{} {
+	return struct {
+		Name, Kind, Folder, Stage string
+		Print                     bool
+	}{s.Name, s.Kind, s.Folder, s.Stage, s.Print}
+}
+
+func (s AuditReportStep) Plan(p StepParams) PlanNode {
+	return newPlanNode(s, "%v %v thinning", s.Folder, s.Stage)
+}
+
+// auditFolder answers the totals for every file in the folder.
+func auditFolder(folder string) (AuditTotals, error) {
+	var t AuditTotals
+	exts := make(map[string]AuditRow)
+	langs := make(map[string]AuditRow)
+	classes := make(map[string]AuditRow)
+	err := fs.WalkDir(os.DirFS(folder), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size := info.Size()
+		t.Files++
+		t.Size += size
+		ext := strings.ToLower(path.Ext(p))
+		addAuditRow(exts, ext, size)
+		addAuditRow(langs, auditLanguage(ext), size)
+		if c, err := classifyFile(filepath.Join(folder, filepath.FromSlash(p))); err == nil {
+			for _, name := range c.Names() {
+				addAuditRow(classes, name, size)
+			}
+		}
+		t.Largest = auditAddLargest(t.Largest, AuditFile{Path: p, Size: size})
+		return nil
+	})
+	t.Extensions, t.Languages, t.Classes = sortAuditRows(exts), sortAuditRows(langs), sortAuditRows(classes)
+	return t, err
+}
+
+// printAuditTotals prints the totals by extension and by class,
+// largest first.
+func printAuditTotals(folder string, t AuditTotals) {
+	fmt.Println("audit", folder, t.Files, "files", t.Size, "bytes")
+	for _, r := range t.Extensions {
+		fmt.Println(r)
+	}
+	fmt.Println("by class:")
+	for _, r := range t.Classes {
+		fmt.Println(r)
+	}
+}
+
+func addAuditRow(rows map[string]AuditRow, name string, size int64) {
+	row := rows[name]
+	row.Name = name
+	row.Count += 1
+	row.Size += size
+	rows[name] = row
+}
+
+// auditLanguage answers the language of files with the extension.
+func auditLanguage(ext string) string {
+	if lang, ok := classifySourceLangs[ext]; ok {
+		return lang
+	}
+	if lang, ok := auditLanguages[ext]; ok {
+		return lang
+	}
+	return AuditOther
+}
+
+// auditAddLargest answers the largest files, with f if it's one of them.
+func auditAddLargest(files []AuditFile, f AuditFile) []AuditFile {
+	if len(files) >= auditLargestLen && f.Size <= files[len(files)-1].Size {
+		return files
+	}
+	files = append(files, f)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Size > files[j].Size
+	})
+	if len(files) > auditLargestLen {
+		files = files[:auditLargestLen]
+	}
+	return files
+}
+
+// sortAuditRows answers the rows, largest first.
+func sortAuditRows(rows map[string]AuditRow) []AuditRow {
+	var sorted []AuditRow
+	for _, r := range rows {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Size != sorted[j].Size {
+			return sorted[i].Size > sorted[j].Size
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+func readAuditReport(file string) (AuditReport, error) {
+	var report AuditReport
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+	return report, json.Unmarshal(b, &report)
+}
+
+// writeAuditReport gathers the audits of every repo and dependency
+// in the output into audit.json and audit.csv, along with the
+// totals across all of them.
+func writeAuditReport(outputFolder string) error {
+	dir := filepath.Join(stateFolderPath(outputFolder), "audit")
+	var reports []AuditReport
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == dir {
+			return fs.SkipDir
+		} else if err != nil || d.IsDir() || filepath.Ext(p) != ".json" {
+			return err
+		}
+		r, err := readAuditReport(p)
+		if err != nil {
+			return fmt.Errorf("%v: %w", p, err)
+		}
+		// Skip folders that have been removed since.
+		if fsExists(filepath.Join(outputFolder, filepath.FromSlash(r.Folder))) {
+			reports = append(reports, r)
+		}
+		return nil
+	})
+	if err != nil || len(reports) < 1 {
+		return err
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Kind != reports[j].Kind {
+			return reports[i].Kind > reports[j].Kind
+		}
+		return reports[i].Name < reports[j].Name
+	})
+	total := AuditReport{Name: AuditTotal, Kind: AuditTotal}
+	for _, r := range reports {
+		total.Before = total.Before.add(r.Before, r.Folder)
+		total.After = total.After.add(r.After, r.Folder)
+	}
+	doc := struct {
+		Total   AuditReport   `json:"total"`
+		Reports []AuditReport `json:"reports"`
+	}{total, reports}
+	b, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(outputFolder, auditJson), b, 0644); err != nil {
+		return err
+	}
+	return writeAuditCsv(filepath.Join(outputFolder, auditCsv), append([]AuditReport{total}, reports...))
+}
+
+// writeAuditCsv writes the reports with one row per measurement,
+// which suits spreadsheets and pivot tables.
+func writeAuditCsv(file string, reports []AuditReport) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"name", "kind", "stage", "group", "key", "files", "size"})
+	for _, r := range reports {
+		stages := []struct {
+			name   string
+			totals *AuditTotals
+		}{{AuditBefore, r.Before}, {AuditAfter, r.After}}
+		for _, stage := range stages {
+			t := stage.totals
+			if t == nil {
+				continue
+			}
+			row := func(group, key string, files int, size int64) {
+				w.Write([]string{r.Name, r.Kind, stage.name, group, key, strconv.Itoa(files), strconv.FormatInt(size, 10)})
+			}
+			row("total", "", t.Files, t.Size)
+			for _, a := range t.Extensions {
+				row("extension", a.Name, a.Count, a.Size)
+			}
+			for _, a := range t.Languages {
+				row("language", a.Name, a.Count, a.Size)
+			}
+			for _, a := range t.Classes {
+				row("class", a.Name, a.Count, a.Size)
+			}
+			for _, a := range t.Largest {
+				row("largest", a.Path, 1, a.Size)
+			}
+		}
+	}
+	w.Flush()
+	return mergeErr(w.Error(), f.Close())
+}
+
+// ------------------------------------------------------------
+// TYPES
+
+// AuditReport is the audit of a single repo or dependency.
+type AuditReport struct {
+	Name   string       `json:"name"`
+	Kind   string       `json:"kind"`
+	Folder string       `json:"folder,omitempty"` // Relative to the output
+	Before *AuditTotals `json:"before,omitempty"` // Before thinning
+	After  *AuditTotals `json:"after,omitempty"`  // After thinning
+}
+
+// AuditTotals are the counts and sizes of the files in a folder.
+type AuditTotals struct {
+	Files      int         `json:"files"`
+	Size       int64       `json:"size"`
+	Extensions []AuditRow  `json:"extensions"`
+	Languages  []AuditRow  `json:"languages"`
+	Classes    []AuditRow  `json:"classes"`
+	Largest    []AuditFile `json:"largest"`
+}
+
+// add answers the sum of t and o, where o's files are in folder.
+func (t *AuditTotals) add(o *AuditTotals, folder string) *AuditTotals {
+	if o == nil {
+		return t
+	}
+	var sum AuditTotals
+	if t != nil {
+		sum = *t
+	}
+	sum.Files += o.Files
+	sum.Size += o.Size
+	sum.Extensions = mergeAuditRows(sum.Extensions, o.Extensions)
+	sum.Languages = mergeAuditRows(sum.Languages, o.Languages)
+	sum.Classes = mergeAuditRows(sum.Classes, o.Classes)
+	for _, f := range o.Largest {
+		sum.Largest = auditAddLargest(sum.Largest, AuditFile{Path: path.Join(folder, f.Path), Size: f.Size})
+	}
+	return &sum
+}
+
+func mergeAuditRows(a, b []AuditRow) []AuditRow {
+	rows := make(map[string]AuditRow)
+	for _, r := range append(append([]AuditRow{}, a...), b...) {
+		row := rows[r.Name]
+		row.Name = r.Name
+		row.Count += r.Count
+		row.Size += r.Size
+		rows[r.Name] = row
+	}
+	return sortAuditRows(rows)
+}
+
+// AuditRow is the count and size of the files in a group,
+// such as an extension or a language.
+type AuditRow struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	Count int    `json:"files"`
+}
+
+// AuditFile is a file in an audit.
+type AuditFile struct {
+	Path string `json:"path"` // Relative to the audited folder, or the output in the total
+	Size int64  `json:"size"`
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+// Audit kinds.
+const (
+	AuditRepo       = "repo"
+	AuditDependency = "dependency"
+	AuditTotal      = "total" // Every repo and dependency in the output
+)
+
+// Audit stages.
+const (
+	AuditBefore = "before"
+	AuditAfter  = "after"
+)
+
+const (
+	AuditOther = "other" // Files that aren't in a known language
+
+	auditJson       = "audit.json"
+	auditCsv        = "audit.csv"
+	auditLargestLen = 10
+)
+
+var (
+	// auditLanguages are the languages that aren't source, by extension.
+	auditLanguages = map[string]string{
+		".html": "HTML", ".htm": "HTML", ".css": "CSS", ".scss": "SCSS", ".md": "Markdown",
+		".json": "JSON", ".xml": "XML", ".yaml": "YAML", ".yml": "YAML", ".toml": "TOML",
+		".mod": "Go Module", ".sum": "Go Module",
+	}
+)
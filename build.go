@@ -13,34 +13,51 @@ func buildSteps(cfg Cfg) ([]Step, error) {
 			fmt.Println("skipping repo", repo.Name)
 			continue
 		}
-		remote := repo.Name
+		remote := cfg.GetRedirect(repo.Name)
 		local := cfg.LocalRepo(repo.Name)
 		if local == "" {
 			panic("No local folder for repo " + repo.Name)
 		}
 		// Clone if needed. Once we have a clone we thin out the data,
 		// which removes git info. If you want to reclone, you need to
-		// manually remove it from the output.
+		// manually remove it from the output. Clones that were
+		// interrupted are redone.
 		cloneSteps := []Step{CloneStep{Repo: remote, LocalFolder: local}}
 		if repo.Branch != "" {
 			cloneSteps = append(cloneSteps, CheckoutStep{Commit: repo.Branch, LocalFolder: local})
 		}
-		steps = append(steps, []Step{OnPathNotExists(local, cloneSteps)}...)
+		cloneSteps = append(cloneSteps, SubmoduleStep{Repo: repo.Name, Folder: local})
+		repoSteps := []Step{OnPathNotDone(local, cloneSteps)}
+		// Keep the history before anything is thinned.
+		if repo.History != "" {
+			repoSteps = append(repoSteps, HistoryStep{Folder: local, Mode: repo.History})
+		}
+		repoSteps = append(repoSteps, LfsStep{Folder: local, Fetch: cfg.Lfs})
+		// Repos that aren't Go have their file types printed before they're thinned.
+		lang := strings.ToLower(repo.Language)
+		audit := AuditReportStep{Name: repo.Name, Kind: AuditRepo, Folder: local, Stage: AuditBefore, Print: lang != "go"}
+		repoSteps = append(repoSteps, audit)
+		if cfg.Licenses {
+			repoSteps = append(repoSteps, LicenseStep{Name: repo.Name, Kind: LicenseRepo, Folder: local})
+		}
 		// Add generic thinning
-		switch strings.ToLower(repo.Language) {
+		thin := cfg.RepoThin(repo)
+		switch lang {
 		case "go":
-			steps = append(steps, GoModStep{Repo: repo, OutputFolder: cfg.Output, LocalFolder: local})
+			repoSteps = append(repoSteps, GoModStep{Repo: repo, OutputFolder: cfg.Output, LocalFolder: local})
 		case "c#":
-			steps = append(steps, AuditStep{Folder: local})
-			steps = append(steps, VsPackagesStep{Folder: local})
+			repoSteps = append(repoSteps, VsPackagesStep{Folder: local})
 		default:
-			steps = append(steps, AuditStep{Folder: local})
-			steps = append(steps, DeleteUnityStep{Folder: local})
+			repoSteps = append(repoSteps, DeleteUnityStep{Folder: local, Rules: thin})
 		}
 		// Remove git data
-		steps = append(steps, DeleteGitStep{Folder: local})
+		repoSteps = append(repoSteps, DeleteGitStep{Folder: local, Rules: thin})
 		// Tidy
-		steps = append(steps, DeleteEmptyFoldersStep{Folder: local, IncludeGit: true})
+		repoSteps = append(repoSteps, DeleteEmptyFoldersStep{Folder: local, IncludeGit: true})
+		audit.Stage, audit.Print = AuditAfter, false
+		repoSteps = append(repoSteps, audit)
+		// Each repo is independent, so it can run in parallel with the others.
+		steps = append(steps, PipelineStep{Name: repo.Name, Steps: repoSteps})
 	}
 	return steps, nil
 }
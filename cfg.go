@@ -2,18 +2,50 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Cfg struct {
-	Output        string         `json:"output,omitempty"`
-	RepoFormat    string         `json:"repo_format,omitempty"`
-	RepoLanguage  string         `json:"repo_language,omitempty"`
-	Repos         []Repo         `json:"repos,omitempty"`
-	RepoRedirects []RepoRedirect `json:"repo_redirects,omitempty"`
+	Output         string         `json:"output,omitempty"`
+	RepoFormat     string         `json:"repo_format,omitempty"`
+	RepoLanguage   string         `json:"repo_language,omitempty"`
+	Repos          []Repo         `json:"repos,omitempty"`
+	RepoRedirects  []RepoRedirect `json:"repo_redirects,omitempty"`
+	Verbose        bool           `json:"verbose,omitempty"`
+	Vcs            string         `json:"vcs,omitempty"`               // The git backend, "native" (the default) for in-process git or "exec" to run the git binary
+	Workers        int            `json:"workers,omitempty"`           // Number of repos and dependencies processed at once. 0 or 1 is serial.
+	Lfs            bool           `json:"lfs,omitempty"`               // Fetch the content of Git LFS pointer files found by the audit
+	GitCache       string         `json:"git_cache,omitempty"`         // A folder of bare mirrors that clones are exported from, shared between runs and outputs
+	GitCacheMaxAge string         `json:"git_cache_max_age,omitempty"` // Prune mirrors unused for this long, i.e. "720h"
+	GitCacheMaxMb  int64          `json:"git_cache_max_mb,omitempty"`  // Prune the least recently used mirrors down to this size
+	GoTransitive   bool           `json:"go_transitive,omitempty"`     // Archive the full Go module graph, not just the go.mod requirements
+	GoAcquire      string         `json:"go_acquire,omitempty"`        // How Go dependencies are acquired, "git" (the default) or "proxy"
+	GoProxy        string         `json:"go_proxy,omitempty"`          // The GOPROXY url for the proxy acquire mode. https, http and file urls are supported.
+	GoMirror       bool           `json:"go_mirror,omitempty"`         // Write the Go dependencies as a GOPROXY tree in <output>/cache/download
+	GoShallow      bool           `json:"go_shallow,omitempty"`        // Fetch only the required tag or commit of Go dependencies
+	GoSparse       bool           `json:"go_sparse,omitempty"`         // Fetch and check out only the folder of Go dependencies in a subdirectory
+	GoVanity       bool           `json:"go_vanity,omitempty"`         // Resolve Go vanity paths with go-import meta tag discovery
+	GoVanityUrl    string         `json:"go_vanity_url,omitempty"`     // Send discovery requests here instead of https://<path>, i.e. a stand-in server
+	Verify         bool           `json:"verify,omitempty"`            // Build the Go repos offline against the archive after a run
+	GoVet          bool           `json:"go_vet,omitempty"`            // Also go vet the Go repos when verifying
+	// Quarantine moves deleted files to a trash folder for each
+	// run, so they can be restored.
+	Quarantine bool `json:"quarantine,omitempty"`
+	// Licenses detects the licenses of every repo and dependency
+	// and writes them to licenses.json and licenses.csv.
+	Licenses bool `json:"licenses,omitempty"`
+	// Thin adds to the thinning of every repo and dependency.
+	Thin ThinRules `json:"thin,omitempty"`
+	// ThinSets are named rule sets that thin rules can apply.
+	// A set with the name of a built-in one replaces it.
+	ThinSets map[string]ThinRules `json:"thin_sets,omitempty"`
 }
 
 type Repo struct {
@@ -21,6 +53,20 @@ type Repo struct {
 	Branch   string     `json:"branch,omitempty"`
 	Language string     `json:"language,omitempty"`
 	Copy     []RepoCopy `json:"copy,omitempty"`
+	// GoTransitive archives the full Go module graph for this repo.
+	GoTransitive bool `json:"go_transitive,omitempty"`
+	// GoAcquire overrides the config's acquire mode for this repo's dependencies.
+	GoAcquire string `json:"go_acquire,omitempty"`
+	// History preserves the git history next to the thinned repo,
+	// as a "bundle" or a bare "mirror".
+	History string `json:"history,omitempty"`
+	// GoShallow fetches only the required version of this repo's dependencies.
+	GoShallow bool `json:"go_shallow,omitempty"`
+	// GoSparse checks out only the module folder of this repo's dependencies.
+	GoSparse bool `json:"go_sparse,omitempty"`
+	// Thin adds to the config's thinning for this repo, but
+	// not its dependencies, which can be shared.
+	Thin ThinRules `json:"thin,omitempty"`
 }
 
 func (r Repo) RepoCopyFrom(repo string) *RepoCopy {
@@ -37,15 +83,81 @@ type RepoCopy struct {
 	To   []string `json:"to,omitempty"`
 }
 
+// RepoRedirect sends a repo somewhere else. From is an exact
+// repo, a glob where * matches one path element and ** matches
+// any number, or a regular expression if Regex is set. Each
+// wildcard or regex group is a capture that To can reference
+// as $1, $2 and so on.
 type RepoRedirect struct {
-	From string `json:"from,omitempty"`
-	To   string `json:"to,omitempty"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+	Regex bool   `json:"regex,omitempty"`
 }
 
+// pattern answers the compiled From, which always matches
+// the full repo.
+func (r RepoRedirect) pattern() (*regexp.Regexp, error) {
+	key := r.From
+	if r.Regex {
+		key = "regex:" + key
+	}
+	if re, ok := redirectPatterns.Load(key); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	var expr string
+	if r.Regex {
+		expr = "^(?:" + r.From + ")$"
+	} else {
+		expr = "^" + redirectGlobExpr(r.From) + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("redirect %v: %w", r.From, err)
+	}
+	redirectPatterns.Store(key, re)
+	return re, nil
+}
+
+// redirectGlobExpr translates a redirect glob to a regular expression.
+func redirectGlobExpr(glob string) string {
+	var sb strings.Builder
+	for len(glob) > 0 {
+		switch {
+		case strings.HasPrefix(glob, "**"):
+			sb.WriteString("(.+)")
+			glob = glob[2:]
+		case strings.HasPrefix(glob, "*"):
+			sb.WriteString("([^/]+)")
+			glob = glob[1:]
+		default:
+			pos := strings.Index(glob, "*")
+			if pos < 0 {
+				pos = len(glob)
+			}
+			sb.WriteString(regexp.QuoteMeta(glob[:pos]))
+			glob = glob[pos:]
+		}
+	}
+	return sb.String()
+}
+
+// redirectPatterns caches the compiled redirect patterns.
+var redirectPatterns sync.Map
+
 func LoadCfgLocal(path string) (Cfg, error) {
 	return LoadCfg(os.DirFS("."), path)
 }
 
+// LoadCfgFile loads the config at path, which can be
+// anywhere on disk.
+func LoadCfgFile(path string) (Cfg, error) {
+	cfg, err := LoadCfg(os.DirFS(filepath.Dir(path)), filepath.Base(path))
+	if err != nil {
+		err = fmt.Errorf("config %v: %w", path, err)
+	}
+	return cfg, err
+}
+
 func LoadCfg(f fs.FS, path string) (Cfg, error) {
 	cfg := Cfg{}
 	b, err := fsReadBytes(f, path)
@@ -53,6 +165,35 @@ func LoadCfg(f fs.FS, path string) (Cfg, error) {
 		return cfg, err
 	}
 	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return cfg, err
+	}
+	if _, err = newVcs(cfg.Vcs); err != nil {
+		return cfg, err
+	}
+	if _, err = cfg.gitCacheMaxAge(); err != nil {
+		return cfg, err
+	}
+	for _, r := range cfg.Repos {
+		if r.History != "" && r.History != HistoryBundle && r.History != HistoryMirror {
+			return cfg, fmt.Errorf("repo %v: unknown history %v", r.Name, r.History)
+		}
+	}
+	for _, r := range cfg.RepoRedirects {
+		if _, err = r.pattern(); err != nil {
+			return cfg, err
+		}
+	}
+	for name, set := range cfg.ThinSets {
+		if _, err = set.compile(cfg.ThinSets); err != nil {
+			return cfg, fmt.Errorf("thin set %v: %w", name, err)
+		}
+	}
+	for _, r := range cfg.Repos {
+		if _, err = cfg.RepoThin(r).compile(cfg.ThinSets); err != nil {
+			return cfg, fmt.Errorf("repo %v: %w", r.Name, err)
+		}
+	}
 	if cfg.RepoLanguage != "" {
 		for i, r := range cfg.Repos {
 			if r.Language == "" {
@@ -64,6 +205,31 @@ func LoadCfg(f fs.FS, path string) (Cfg, error) {
 	return cfg, err
 }
 
+// FilterRepos answers a copy of the config with only the named
+// repos. Names can be the full repo name or the local folder name.
+func (c Cfg) FilterRepos(only []string) (Cfg, error) {
+	var repos []Repo
+	for _, name := range only {
+		found := false
+		for _, r := range c.Repos {
+			if r.Name == name || filepath.Base(c.LocalRepo(r.Name)) == name {
+				repos = append(repos, r)
+				found = true
+			}
+		}
+		if !found {
+			return c, fmt.Errorf("no repo named %v", name)
+		}
+	}
+	c.Repos = repos
+	return c, nil
+}
+
+// RepoThin answers the thin rules for the repo's own folder.
+func (c Cfg) RepoThin(repo Repo) ThinRules {
+	return c.Thin.merge(repo.Thin)
+}
+
 func (c Cfg) RemoteRepoHttps(repo string) string {
 	return c.formatGitHttps(repo)
 }
@@ -80,19 +246,60 @@ func (c Cfg) LocalRepo(repo string) string {
 	return filepath.Join(c.Output, repo[pos+1:])
 }
 
+// GetRedirect answers where repo should be acquired from. The
+// redirects are tried in order and the first match wins, so
+// specific rules go before general ones.
 func (c Cfg) GetRedirect(repo string) string {
+	if to, ok := c.MatchRedirect(repo); ok {
+		return to
+	}
+	return repo
+}
+
+// MatchRedirect answers the redirected repo and true if any
+// redirect matches repo.
+func (c Cfg) MatchRedirect(repo string) (string, bool) {
 	for _, d := range c.RepoRedirects {
-		if d.From == repo {
-			return d.To
+		re, err := d.pattern()
+		if err != nil {
+			continue
+		}
+		m := re.FindStringSubmatchIndex(repo)
+		if m == nil {
+			continue
 		}
+		return string(re.ExpandString(nil, d.To, repo, m)), true
 	}
-	return repo
+	return repo, false
+}
+
+func (c Cfg) gitCacheMaxAge() (time.Duration, error) {
+	if c.GitCacheMaxAge == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.GitCacheMaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("git_cache_max_age: %w", err)
+	}
+	return d, nil
 }
 
 func (c Cfg) formatGitHttps(s string) string {
+	if isRemoteUrl(s) {
+		return s
+	}
 	return "https://" + s
 }
 
 func (c Cfg) formatGitSsh(s string) string {
+	if isRemoteUrl(s) {
+		return s
+	}
 	return "git@" + strings.Replace(s, "/", ":", 1) + ".git"
 }
+
+// isRemoteUrl answers true if the repo is already a full remote,
+// such as a redirect to a file:// url or a local path.
+func isRemoteUrl(s string) bool {
+	return strings.Contains(s, "://") || filepath.IsAbs(s)
+}
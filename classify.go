@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// FileClasses are the kinds of content a file holds. A file
+// can be several, i.e. a Makefile is text and a build-script.
+type FileClasses uint
+
+// classifyFile sniffs the start of the file, along with its name,
+// to answer what it holds.
+func classifyFile(path string) (FileClasses, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	head := make([]byte, classifySniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	return classifyContent(filepath.Base(path), head[:n]), nil
+}
+
+// classifyContent answers the classes of a file named name that
+// starts with head.
+func classifyContent(name string, head []byte) FileClasses {
+	var c FileClasses
+	ext := strings.ToLower(filepath.Ext(name))
+	if classifyIsBinary(head) {
+		c |= ClassBinary
+	} else {
+		c |= ClassText
+	}
+	// Magic numbers are short enough to start a text file too.
+	switch {
+	case c.Has(ClassText):
+		if ext == ".svg" {
+			c |= ClassImage
+		}
+	case classifyMagic(head, classifyExecutables):
+		c |= ClassExecutable
+	case classifyMagic(head, classifyArchives) || classifyIsTar(head):
+		c |= ClassArchive
+	case strings.HasPrefix(http.DetectContentType(head), "image/") || classifyMagic(head, classifyImages):
+		c |= ClassImage
+	}
+	if c.Has(ClassText) {
+		if _, ok := classifySourceLangs[ext]; ok || bytes.HasPrefix(head, []byte("#!")) {
+			c |= ClassSource
+		}
+		if classifyIsLicense(name) {
+			c |= ClassLicense
+		}
+		if classifyIsBuildScript(name) {
+			c |= ClassBuildScript
+		}
+	}
+	return c
+}
+
+// Has answers true if c includes all of the classes in o.
+func (c FileClasses) Has(o FileClasses) bool {
+	return o != 0 && c&o == o
+}
+
+// Names answers the name of each class, sorted.
+func (c FileClasses) Names() []string {
+	var names []string
+	for name, class := range fileClassNames {
+		if c.Has(class) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c FileClasses) String() string {
+	return strings.Join(c.Names(), ",")
+}
+
+// parseFileClass answers the class with the name.
+func parseFileClass(name string) (FileClasses, bool) {
+	c, ok := fileClassNames[strings.ToLower(name)]
+	return c, ok
+}
+
+// classifyIsBinary answers true for content git would consider
+// binary, i.e. with a NUL byte, or that's mostly invalid UTF-8.
+// Latin-1 and CP-1252 text only has the odd invalid byte, such
+// as an accented letter, so it's still text.
+func classifyIsBinary(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+	// The sniff can cut a rune in half.
+	if len(head) > utf8.UTFMax {
+		head = head[:len(head)-utf8.UTFMax]
+	}
+	bad, n := 0, len(head)
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			bad++
+		}
+		head = head[size:]
+	}
+	return bad*100 > n*classifyBinaryPercent
+}
+
+func classifyMagic(head []byte, magics [][]byte) bool {
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func classifyIsTar(head []byte) bool {
+	return len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar"))
+}
+
+// classifyIsLicense answers true for the names licenses and
+// notices are conventionally given, i.e. LICENSE-MIT or COPYING.txt.
+func classifyIsLicense(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, prefix := range classifyLicenseNames {
+		if upper == prefix {
+			return true
+		}
+		if strings.HasPrefix(upper, prefix) && strings.ContainsAny(upper[len(prefix):len(prefix)+1], ".-_") {
+			return true
+		}
+	}
+	return false
+}
+
+func classifyIsBuildScript(name string) bool {
+	lower := strings.ToLower(name)
+	if classifyBuildNames[lower] || classifyBuildExts[strings.ToLower(filepath.Ext(name))] {
+		return true
+	}
+	// Dockerfile.dev, Makefile.in and the like.
+	for _, prefix := range []string{"dockerfile.", "makefile.", "containerfile."} {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+const (
+	ClassText FileClasses = 1 << iota
+	ClassBinary
+	ClassImage
+	ClassArchive
+	ClassExecutable // Native code and WebAssembly
+	ClassSource     // Source code and scripts
+	ClassLicense    // Licenses and notices
+	ClassBuildScript
+)
+
+const (
+	classifySniffLen      = 1024
+	classifyBinaryPercent = 30 // Content with more invalid UTF-8 than this is binary
+)
+
+var (
+	fileClassNames = map[string]FileClasses{
+		"text":         ClassText,
+		"binary":       ClassBinary,
+		"image":        ClassImage,
+		"archive":      ClassArchive,
+		"executable":   ClassExecutable,
+		"source":       ClassSource,
+		"license":      ClassLicense,
+		"build-script": ClassBuildScript,
+	}
+
+	classifyExecutables = [][]byte{
+		[]byte("\x7fELF"),
+		[]byte("MZ"),               // Windows PE
+		[]byte("\xfe\xed\xfa\xce"), // Mach-O
+		[]byte("\xfe\xed\xfa\xcf"),
+		[]byte("\xce\xfa\xed\xfe"),
+		[]byte("\xcf\xfa\xed\xfe"),
+		[]byte("\xca\xfe\xba\xbe"), // Mach-O universal, and Java classes
+		[]byte("\x00asm"),
+	}
+	classifyArchives = [][]byte{
+		[]byte("PK\x03\x04"), // Zip, and the jar, nupkg and apk built on it
+		[]byte("PK\x05\x06"),
+		[]byte("\x1f\x8b"),             // gzip
+		[]byte("BZh"),                  // bzip2
+		[]byte("\xfd7zXZ\x00"),         // xz
+		[]byte("7z\xbc\xaf\x27\x1c"),   // 7z
+		[]byte("Rar!\x1a\x07"),         // rar
+		[]byte("\x28\xb5\x2f\xfd"),     // zstd
+		[]byte("!<arch>\n"),            // ar, and the .a and .deb built on it
+		[]byte("\xd0\xcf\x11\xe0\xa1"), // Compound files, i.e. msi
+	}
+	// classifyImages are formats http.DetectContentType doesn't know.
+	classifyImages = [][]byte{
+		[]byte("8BPS"),         // Photoshop
+		[]byte("II*\x00"),      // TIFF
+		[]byte("MM\x00*"),      // TIFF
+		[]byte("\x76\x2f\x31"), // OpenEXR
+		[]byte("#?RADIANCE"),   // HDR
+		[]byte("icns"),
+	}
+
+	classifyLicenseNames = []string{"LICENSE", "LICENCE", "COPYING", "COPYRIGHT", "NOTICE", "PATENTS", "UNLICENSE"}
+
+	classifyBuildNames = map[string]bool{
+		"makefile": true, "gnumakefile": true, "dockerfile": true, "containerfile": true,
+		"cmakelists.txt": true, "configure": true, "configure.ac": true, "meson.build": true,
+		"build": true, "build.bazel": true, "workspace": true, "workspace.bazel": true,
+		"build.gradle": true, "build.gradle.kts": true, "settings.gradle": true, "settings.gradle.kts": true,
+		"pom.xml": true, "build.xml": true, "rakefile": true, "justfile": true, "jenkinsfile": true,
+		"vagrantfile": true, "sconstruct": true, "sconscript": true, "magefile.go": true,
+	}
+	classifyBuildExts = map[string]bool{
+		".mk": true, ".mak": true, ".cmake": true, ".bzl": true, ".dockerfile": true,
+		".csproj": true, ".vcxproj": true, ".fsproj": true, ".vbproj": true, ".sln": true,
+	}
+	// classifySourceLangs are the languages of source files by extension.
+	classifySourceLangs = map[string]string{
+		".go": "Go", ".c": "C", ".h": "C", ".cc": "C++", ".cpp": "C++", ".cxx": "C++", ".hpp": "C++", ".hh": "C++", ".hxx": "C++", ".inl": "C++",
+		".cs": "C#", ".fs": "F#", ".vb": "Visual Basic", ".java": "Java", ".kt": "Kotlin", ".kts": "Kotlin", ".scala": "Scala", ".groovy": "Groovy",
+		".swift": "Swift", ".m": "Objective-C", ".mm": "Objective-C++", ".rs": "Rust", ".zig": "Zig", ".d": "D", ".nim": "Nim",
+		".py": "Python", ".rb": "Ruby", ".pl": "Perl", ".pm": "Perl", ".php": "PHP", ".lua": "Lua", ".r": "R", ".dart": "Dart",
+		".js": "JavaScript", ".mjs": "JavaScript", ".cjs": "JavaScript", ".jsx": "JavaScript", ".ts": "TypeScript", ".tsx": "TypeScript",
+		".sh": "Shell", ".bash": "Shell", ".zsh": "Shell", ".fish": "Shell", ".ps1": "PowerShell", ".psm1": "PowerShell", ".bat": "Batch", ".cmd": "Batch",
+		".asm": "Assembly", ".s": "Assembly", ".hlsl": "HLSL", ".glsl": "GLSL", ".shader": "ShaderLab", ".cginc": "HLSL", ".compute": "HLSL",
+		".hs": "Haskell", ".ml": "OCaml", ".ex": "Elixir", ".exs": "Elixir", ".erl": "Erlang", ".clj": "Clojure", ".sql": "SQL", ".proto": "Protocol Buffers",
+	}
+)
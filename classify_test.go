@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestClassifyIsBinary(t *testing.T) {
+	random := make([]byte, classifySniffLen)
+	rand.New(rand.NewSource(1)).Read(random)
+	for i, b := range random {
+		if b == 0 {
+			random[i] = 0xff
+		}
+	}
+	// Accented letters are single invalid bytes in Latin-1.
+	latin1 := []byte(strings.Repeat("Caf\xe9 cr\xe8me br\xfbl\xe9e, na\xefve fa\xe7ade.\n", 40))
+	cases := []struct {
+		name   string
+		head   []byte
+		binary bool
+	}{
+		{"utf8", []byte(strings.Repeat("Café crème brûlée, naïve façade.\n", 30)), false},
+		{"latin1", latin1[:classifySniffLen], false},
+		{"nul", []byte("text\x00text"), true},
+		{"random", random, true},
+	}
+	for _, c := range cases {
+		if got := classifyIsBinary(c.head); got != c.binary {
+			t.Errorf("%v: want binary %v, have %v", c.name, c.binary, got)
+		}
+	}
+	if c := classifyContent("README", latin1[:classifySniffLen]); !c.Has(ClassText) {
+		t.Errorf("want Latin-1 README to be text, have %v", c.Names())
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runCli parses the command line and runs the requested subcommand.
+// With no subcommand guzzle performs a run, which matches the
+// original behaviour of loading cfg.json from the working directory.
+func runCli(args []string) error {
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := cliCmds[name]
+	if !ok {
+		printCliUsage()
+		return fmt.Errorf("unknown command %v", name)
+	}
+	flags := flag.NewFlagSet("guzzle "+name, flag.ContinueOnError)
+	opts := cliOpts{}
+	flags.StringVar(&opts.Config, "config", "cfg.json", "path to the config file")
+	flags.StringVar(&opts.Output, "output", "", "override the output folder from the config")
+	flags.Var(&opts.Only, "only", "only process the named repo (repeatable, or comma separated)")
+	flags.BoolVar(&opts.Verbose, "verbose", false, "print detailed progress")
+	if cmd.Flags != nil {
+		cmd.Flags(flags, &opts)
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: guzzle %v [flags]\n\n%v\n\n", name, cmd.Usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		// Asking for help isn't a failure.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	opts.Args = flags.Args()
+	cfg, err := opts.loadCfg()
+	if err != nil {
+		return err
+	}
+	return cmd.Run(cfg, opts)
+}
+
+func printCliUsage() {
+	fmt.Fprintln(os.Stderr, "usage: guzzle <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	var names []string
+	for name := range cliCmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8v %v\n", name, cliCmds[name].Usage)
+	}
+}
+
+// ------------------------------------------------------------
+// COMMANDS
+
+func cliRun(cfg Cfg, opts cliOpts) error {
+	output, err := run(cfg)
+	errs := reportErrors(output.Errors)
+	return mergeErr(err, errs)
+}
+
+func cliRunFlags(flags *flag.FlagSet, opts *cliOpts) {
+	flags.IntVar(&opts.Workers, "workers", 0, "number of repos and dependencies to process at once (overrides the config)")
+}
+
+func cliPlan(cfg Cfg, opts cliOpts) error {
+	nodes, err := plan(cfg)
+	if err != nil {
+		return err
+	}
+	if opts.Json {
+		return writePlanJson(os.Stdout, nodes)
+	}
+	writePlanTree(os.Stdout, nodes)
+	return nil
+}
+
+func cliPlanFlags(flags *flag.FlagSet, opts *cliOpts) {
+	flags.BoolVar(&opts.Json, "json", false, "write the plan as JSON")
+}
+
+// cliAudit audits the archived repos as they are now, and writes
+// the report along with the audits of the last run.
+func cliAudit(cfg Cfg, opts cliOpts) error {
+	p := StepParams{Cfg: cfg, Output: &StepOutput{}}
+	for _, repo := range cfg.Repos {
+		local := cfg.LocalRepo(repo.Name)
+		if local == "" || fsNotExists(local) {
+			p.AddError(fmt.Errorf("no archive for repo %v", repo.Name))
+			continue
+		}
+		// The git data is gone, so pointers can only be reported.
+		audit := AuditReportStep{Name: repo.Name, Kind: AuditRepo, Folder: local, Stage: AuditAfter, Print: true}
+		steps := []Step{audit, LfsStep{Folder: local}}
+		if err := runSteps(p, steps); err != nil {
+			return err
+		}
+	}
+	if err := writeAuditReport(cfg.Output); err != nil {
+		return err
+	}
+	return reportErrors(p.Output.Errors)
+}
+
+// cliVerify checks that every configured repo has been archived,
+// that nothing changed since the manifest was written, and that
+// the Go repos build offline against the archive.
+func cliVerify(cfg Cfg, opts cliOpts) error {
+	fmt.Println("verify hashes", cfg.Output)
+	errs, err := verifyArchiveManifest(cfg.Output)
+	if os.IsNotExist(err) {
+		errs = append(errs, fmt.Errorf("no %v in %v, run guzzle to write one", manifestJson, cfg.Output))
+	} else if err != nil {
+		return err
+	}
+	for _, repo := range cfg.Repos {
+		local := cfg.LocalRepo(repo.Name)
+		if local == "" || fsNotExists(local) {
+			errs = append(errs, fmt.Errorf("missing repo %v", repo.Name))
+			continue
+		}
+		empty, err := fsDirEmpty(os.DirFS(local), ".")
+		if err != nil {
+			errs = append(errs, err)
+		} else if empty {
+			errs = append(errs, fmt.Errorf("empty repo %v", repo.Name))
+		} else if opts.Verbose {
+			fmt.Println("ok", repo.Name)
+		}
+	}
+	p := StepParams{Cfg: cfg, Output: &StepOutput{}}
+	if cfg.GoVanity {
+		p.GoImports = newGoImportResolver(cfg.Output, cfg.GoVanityUrl)
+	}
+	if err := (VerifyStep{Vet: cfg.GoVet || opts.Vet}).Run(p); err != nil {
+		return err
+	}
+	return reportErrors(append(errs, p.Output.Errors...))
+}
+
+func cliVerifyFlags(flags *flag.FlagSet, opts *cliOpts) {
+	flags.BoolVar(&opts.Vet, "vet", false, "also go vet the Go repos")
+}
+
+// cliPrune prunes the git cache to its limits. The flags
+// override the limits in the config.
+func cliPrune(cfg Cfg, opts cliOpts) error {
+	if cfg.GitCache == "" {
+		return fmt.Errorf("no git_cache in the config")
+	}
+	if opts.MaxAge != "" {
+		cfg.GitCacheMaxAge = opts.MaxAge
+		if _, err := cfg.gitCacheMaxAge(); err != nil {
+			return err
+		}
+	}
+	if opts.MaxMb > 0 {
+		cfg.GitCacheMaxMb = opts.MaxMb
+	}
+	return pruneGitCache(cfg)
+}
+
+func cliPruneFlags(flags *flag.FlagSet, opts *cliOpts) {
+	flags.StringVar(&opts.MaxAge, "max-age", "", "remove mirrors unused for this long, i.e. 720h")
+	flags.Int64Var(&opts.MaxMb, "max-mb", 0, "remove the least recently used mirrors down to this size")
+}
+
+// cliRestore puts back the files a quarantined run deleted.
+// With no run it lists the runs that can be restored.
+func cliRestore(cfg Cfg, opts cliOpts) error {
+	runs, err := trashRuns(cfg.Output)
+	if err != nil {
+		return err
+	}
+	switch len(opts.Args) {
+	case 0:
+		fmt.Println("quarantined runs in", cfg.Output+":")
+		for _, run := range runs {
+			fmt.Println(" ", run)
+		}
+		return nil
+	case 1:
+	default:
+		return fmt.Errorf("usage: guzzle restore <run>")
+	}
+	run := opts.Args[0]
+	if run == "latest" && len(runs) > 0 {
+		run = runs[len(runs)-1]
+	}
+	// Only a run folder, never a path that escapes the trash.
+	found := false
+	for _, r := range runs {
+		if r == run {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no quarantined run %v in %v", run, cfg.Output)
+	}
+	restored, errs, err := restoreTrash(cfg.Output, run)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no quarantined run %v in %v", run, cfg.Output)
+	} else if err != nil {
+		return err
+	}
+	fmt.Println("restored", restored, "files from", run)
+	return reportErrors(errs)
+}
+
+// reportErrors prints the errors and answers a single error
+// summarizing them, so scripts can rely on the exit code.
+func reportErrors(errs []error) error {
+	if len(errs) < 1 {
+		return nil
+	}
+	fmt.Println("There were errors:")
+	for _, e := range errs {
+		fmt.Println(e)
+	}
+	return fmt.Errorf("%v errors", len(errs))
+}
+
+// ------------------------------------------------------------
+// TYPES
+
+type cliCmd struct {
+	Usage string
+	Run   func(Cfg, cliOpts) error
+	Flags func(*flag.FlagSet, *cliOpts) // Optional command-specific flags
+}
+
+// cliOpts are the flags shared by all commands.
+type cliOpts struct {
+	Config  string
+	Output  string
+	Only    stringsFlag
+	Verbose bool
+	Json    bool
+	Workers int
+	Vet     bool
+	MaxAge  string
+	MaxMb   int64
+	Args    []string // Any remaining positional arguments
+}
+
+// loadCfg loads the config and applies the command line overrides.
+func (o cliOpts) loadCfg() (Cfg, error) {
+	cfg, err := LoadCfgFile(o.Config)
+	if err != nil {
+		return cfg, err
+	}
+	if o.Output != "" {
+		cfg.Output = o.Output
+	}
+	if o.Verbose {
+		cfg.Verbose = true
+	}
+	if o.Workers > 0 {
+		cfg.Workers = o.Workers
+	}
+	if len(o.Only) > 0 {
+		return cfg.FilterRepos(o.Only)
+	}
+	return cfg, nil
+}
+
+// stringsFlag is a flag that can be repeated and/or comma separated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*f = append(*f, v)
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+var cliCmds = map[string]cliCmd{
+	"run":     {"clone, thin and archive every repo in the config", cliRun, cliRunFlags},
+	"plan":    {"print the steps a run would perform, without touching disk or git", cliPlan, cliPlanFlags},
+	"audit":   {"print file type audits of the archived repos and write audit.json and audit.csv", cliAudit, nil},
+	"prune":   {"remove old mirrors from the git cache", cliPrune, cliPruneFlags},
+	"restore": {"put back the files a quarantined run deleted, i.e. guzzle restore <run> or latest", cliRestore, nil},
+	"verify":  {"check that every repo in the config has been archived, matches the manifest, and that Go repos build offline", cliVerify, cliVerifyFlags},
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// gitCache is a folder of bare mirrors, keyed by remote, that
+// clones are exported from. It lives outside the output so
+// runs and outputs can share it.
+type gitCache struct {
+	Dir string
+}
+
+// gitCache answers the configured cache, or nil if there isn't one.
+func (p StepParams) gitCache() *gitCache {
+	if p.Cfg.GitCache == "" {
+		return nil
+	}
+	return &gitCache{Dir: p.Cfg.GitCache}
+}
+
+// MirrorPath answers the mirror folder for the remote. The ssh
+// and https forms of a remote share a mirror.
+func (c gitCache) MirrorPath(remote string) string {
+	key := remote
+	if i := strings.Index(key, "://"); i >= 0 {
+		key = key[i+3:]
+	} else if strings.HasPrefix(key, "git@") {
+		key = strings.Replace(strings.TrimPrefix(key, "git@"), ":", "/", 1)
+	}
+	if i := strings.Index(key, "@"); i >= 0 && i < strings.Index(key+"/", "/") {
+		// Drop any user info
+		key = key[i+1:]
+	}
+	key = path.Clean("/" + strings.TrimSuffix(key, ".git"))
+	return filepath.Join(c.Dir, filepath.FromSlash(key)+".git")
+}
+
+// Export updates the mirror for the remote, then clones it into
+// the folder. Mirrors are only updated once per run. Both hold
+// the mirror's lock, since other runs can share the cache.
+func (c gitCache) Export(p StepParams, remote, folder string) error {
+	dir := c.MirrorPath(remote)
+	err := p.Shared.Do("gitcache:"+remote, func() error {
+		fmt.Println("git mirror", remote, dir)
+		unlock, err := c.lock(dir)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		return p.vcs().Mirror(remote, dir)
+	})
+	if err != nil {
+		return err
+	}
+	unlock, err := c.lock(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if fsNotExists(dir) {
+		// Pruned by another run since it was mirrored.
+		if err = p.vcs().Mirror(remote, dir); err != nil {
+			return err
+		}
+	}
+	// Record the use, for pruning.
+	now := time.Now()
+	if err := os.Chtimes(dir, now, now); err != nil {
+		return err
+	}
+	return p.vcs().Export(dir, folder, remote)
+}
+
+// lock takes the lock file beside the mirror, waiting for any other
+// run or pipeline that holds it, and answers the func that releases
+// it.
+func (c gitCache) lock(dir string) (func(), error) {
+	for {
+		unlock, ok, err := c.tryLock(dir)
+		if err != nil || ok {
+			return unlock, err
+		}
+		time.Sleep(gitCacheLockPoll)
+	}
+}
+
+// tryLock takes the lock file beside the mirror if no one else holds
+// it. The holder touches the lock every gitCacheLockRefresh, so a lock
+// older than gitCacheLockStale was left by a run that died, and is
+// taken over.
+func (c gitCache) tryLock(dir string) (func(), bool, error) {
+	path := dir + ".lock"
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, false, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) && c.stale(path) {
+		os.Remove(path)
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if os.IsExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	f.Close()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(gitCacheLockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(path, now, now)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		os.Remove(path)
+	}, true, nil
+}
+
+func (c gitCache) stale(lockPath string) bool {
+	info, err := os.Stat(lockPath)
+	return err == nil && time.Since(info.ModTime()) > gitCacheLockStale
+}
+
+// gitCacheMirror is a mirror in the cache.
+type gitCacheMirror struct {
+	Path string
+	Used time.Time
+	Size int64
+}
+
+// Prune removes mirrors that haven't been used within maxAge,
+// then the least recently used mirrors until the cache is no
+// larger than maxSize. Zero disables either limit. It answers
+// the removed mirrors.
+func (c gitCache) Prune(maxAge time.Duration, maxSize int64) ([]string, error) {
+	mirrors, err := c.mirrors()
+	if err != nil {
+		return nil, err
+	}
+	// Most recently used first
+	sort.Slice(mirrors, func(i, j int) bool {
+		return mirrors[i].Used.After(mirrors[j].Used)
+	})
+	var removed []string
+	var size int64
+	for _, m := range mirrors {
+		size += m.Size
+		expired := maxAge > 0 && time.Since(m.Used) > maxAge
+		full := maxSize > 0 && size > maxSize
+		if !expired && !full {
+			continue
+		}
+		// Mirrors in use by another run are kept, and the lock
+		// stops a run from using one while it's removed.
+		unlock, ok, err := c.tryLock(m.Path)
+		if err != nil {
+			return removed, err
+		} else if !ok {
+			continue
+		}
+		err = os.RemoveAll(m.Path)
+		unlock()
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, m.Path)
+		size -= m.Size
+	}
+	return removed, nil
+}
+
+// mirrors answers every mirror in the cache.
+func (c gitCache) mirrors() ([]gitCacheMirror, error) {
+	var mirrors []gitCacheMirror
+	err := filepath.WalkDir(c.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || !strings.HasSuffix(p, ".git") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size, err := gitCacheSize(p)
+		if err != nil {
+			return err
+		}
+		mirrors = append(mirrors, gitCacheMirror{Path: p, Used: info.ModTime(), Size: size})
+		return filepath.SkipDir
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return mirrors, err
+}
+
+func gitCacheSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err == nil {
+			size += info.Size()
+		}
+		return err
+	})
+	return size, err
+}
+
+// pruneGitCache prunes the configured cache to its limits.
+func pruneGitCache(cfg Cfg) error {
+	if cfg.GitCache == "" || (cfg.GitCacheMaxAge == "" && cfg.GitCacheMaxMb == 0) {
+		return nil
+	}
+	maxAge, err := cfg.gitCacheMaxAge()
+	if err != nil {
+		return err
+	}
+	removed, err := gitCache{Dir: cfg.GitCache}.Prune(maxAge, cfg.GitCacheMaxMb*1024*1024)
+	for _, r := range removed {
+		fmt.Println("pruned", r)
+	}
+	return err
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+const (
+	gitCacheLockPoll    = 200 * time.Millisecond
+	gitCacheLockRefresh = time.Minute
+	gitCacheLockStale   = 10 * time.Minute
+)
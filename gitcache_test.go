@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGitCacheLock(t *testing.T) {
+	c := gitCache{Dir: t.TempDir()}
+	dir := c.MirrorPath("https://example.com/org/repo")
+	unlock, ok, err := c.tryLock(dir)
+	if err != nil || !ok {
+		t.Fatalf("want the lock, have %v %v", ok, err)
+	}
+	if _, ok, _ := c.tryLock(dir); ok {
+		t.Error("want the lock to be held")
+	}
+	// A lock that isn't touched was left by a run that died.
+	old := time.Now().Add(-2 * gitCacheLockStale)
+	if err := os.Chtimes(dir+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	takeover, ok, err := c.tryLock(dir)
+	if err != nil || !ok {
+		t.Fatalf("want a stale lock taken over, have %v %v", ok, err)
+	}
+	takeover()
+	unlock()
+	if fsExists(dir + ".lock") {
+		t.Error("want the lock released")
+	}
+}
+
+func TestGitCachePruneLocked(t *testing.T) {
+	c := gitCache{Dir: t.TempDir()}
+	used := c.MirrorPath("https://example.com/org/used")
+	unused := c.MirrorPath("https://example.com/org/unused")
+	for _, dir := range []string{used, unused} {
+		writeTestFile(t, filepath.Join(dir, "HEAD"), "ref: refs/heads/main\n")
+		old := time.Now().Add(-48 * time.Hour)
+		if err := os.Chtimes(dir, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unlock, err := c.lock(used)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	removed, err := c.Prune(time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != unused {
+		t.Errorf("want %v removed, have %v", unused, removed)
+	}
+	if fsNotExists(used) {
+		t.Error("want the locked mirror kept")
+	}
+	if fsExists(unused + ".lock") {
+		t.Error("want prune to release its lock")
+	}
+}
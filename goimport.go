@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// goImportResolver maps module paths to their real VCS root and
+// subdirectory with go-import meta tag discovery, the same way the
+// go tool resolves vanity paths like gopkg.in or golang.org/x. The
+// results are cached on disk in the state folder.
+type goImportResolver struct {
+	BaseUrl  string // Discovery requests go to BaseUrl/<path>?go-get=1. Defaults to https://<path>, override for a stand-in server.
+	Client   *http.Client
+	ReadOnly bool // Don't save the cache, i.e. while planning
+
+	path        string
+	cache       map[string]GoImport // Keyed by prefix
+	mu          sync.Mutex
+	discovering *keyedOnce // Discovery runs once per path, outside the lock
+}
+
+// GoImport is a single go-import meta tag, along with the
+// go-source tag for the same prefix, if there is one.
+type GoImport struct {
+	Prefix   string    `json:"prefix"`
+	Vcs      string    `json:"vcs"`
+	RepoRoot string    `json:"repo_root"`
+	Subdir   string    `json:"subdir,omitempty"`
+	Source   *GoSource `json:"source,omitempty"`
+}
+
+// GoSource is a go-source meta tag.
+type GoSource struct {
+	Home      string `json:"home,omitempty"`
+	Directory string `json:"directory,omitempty"`
+	File      string `json:"file,omitempty"`
+}
+
+func newGoImportResolver(outputFolder, baseUrl string) *goImportResolver {
+	r := &goImportResolver{BaseUrl: strings.TrimSuffix(baseUrl, "/"), Client: &http.Client{Timeout: 30 * time.Second}}
+	r.path = filepath.Join(stateFolderPath(outputFolder), "go-import.json")
+	r.cache = make(map[string]GoImport)
+	r.discovering = newKeyedOnce()
+	if b, err := os.ReadFile(r.path); err == nil {
+		json.Unmarshal(b, &r.cache)
+	}
+	return r
+}
+
+// Resolve answers the go-import for the module path.
+func (r *goImportResolver) Resolve(modPath string) (GoImport, error) {
+	if imp, ok := r.lookup(modPath); ok {
+		return imp, nil
+	}
+	// A slow host only holds up the callers waiting on the same path.
+	err := r.discovering.Do(modPath, func() error {
+		imp, err := r.discover(modPath)
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.cache[imp.Prefix] = imp
+		if r.ReadOnly {
+			return nil
+		}
+		return r.save()
+	})
+	if err != nil {
+		return GoImport{}, err
+	}
+	imp, _ := r.lookup(modPath)
+	return imp, nil
+}
+
+// lookup answers the cached import for the path.
+func (r *goImportResolver) lookup(modPath string) (GoImport, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cached(modPath)
+}
+
+// cached answers the cached import with the longest prefix
+// that matches the path. Must be called locked.
+func (r *goImportResolver) cached(modPath string) (GoImport, bool) {
+	var ans GoImport
+	found := false
+	for prefix, imp := range r.cache {
+		if goImportHasPrefix(modPath, prefix) && len(prefix) > len(ans.Prefix) {
+			ans, found = imp, true
+		}
+	}
+	return ans, found
+}
+
+func (r *goImportResolver) discover(modPath string) (GoImport, error) {
+	u := "https://" + modPath + "?go-get=1"
+	if r.BaseUrl != "" {
+		u = r.BaseUrl + "/" + modPath + "?go-get=1"
+	}
+	fmt.Println("go-import", u)
+	resp, err := r.Client.Get(u)
+	if err != nil {
+		return GoImport{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return GoImport{}, fmt.Errorf("go-import %v: %v", u, resp.Status)
+	}
+	imports, sources, err := parseGoImportMeta(resp.Body)
+	if err != nil {
+		return GoImport{}, fmt.Errorf("go-import %v: %w", u, err)
+	}
+	for _, imp := range imports {
+		// "mod" imports point at a proxy, not a repo
+		if imp.Vcs != "git" || !goImportHasPrefix(modPath, imp.Prefix) {
+			continue
+		}
+		if src, ok := sources[imp.Prefix]; ok {
+			imp.Source = &src
+		}
+		return imp, nil
+	}
+	return GoImport{}, fmt.Errorf("go-import %v: no git go-import meta tag for %v", u, modPath)
+}
+
+// save writes the cache. Must be called locked.
+func (r *goImportResolver) save() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), os.ModePerm); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r.cache, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, b, 0644)
+}
+
+// Repo answers the repo root in the form used by the config,
+// i.e. "go.googlesource.com/net" for "https://go.googlesource.com/net".
+func (imp GoImport) Repo() string {
+	repo := imp.RepoRoot
+	if pos := strings.Index(repo, "://"); pos >= 0 {
+		repo = repo[pos+3:]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(repo, "/"), ".git")
+}
+
+// ------------------------------------------------------------
+// PARSING
+
+// parseGoImportMeta answers the go-import and go-source meta tags
+// in the html, with the sources keyed by prefix. Like the go tool,
+// it's lenient and stops at the end of the head.
+func parseGoImportMeta(rd io.Reader) ([]GoImport, map[string]GoSource, error) {
+	var imports []GoImport
+	sources := make(map[string]GoSource)
+	d := xml.NewDecoder(rd)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(imports) > 0 {
+				break
+			}
+			return nil, nil, err
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			break
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			break
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+		name, content := goImportAttr(e, "name"), goImportAttr(e, "content")
+		fields := strings.Fields(content)
+		switch {
+		case name == "go-import" && (len(fields) == 3 || len(fields) == 4):
+			imp := GoImport{Prefix: fields[0], Vcs: fields[1], RepoRoot: fields[2]}
+			if len(fields) == 4 {
+				imp.Subdir = fields[3]
+			}
+			imports = append(imports, imp)
+		case name == "go-source" && len(fields) == 4:
+			sources[fields[0]] = GoSource{Home: fields[1], Directory: fields[2], File: fields[3]}
+		}
+	}
+	return imports, sources, nil
+}
+
+func goImportAttr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// goImportHasPrefix answers true if the path is the prefix or
+// a path inside it.
+func goImportHasPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// goImportKnownHost answers true if the host's repos are always
+// the first three path elements, so no discovery is needed.
+func goImportKnownHost(modPath string) bool {
+	host := strings.SplitN(modPath, "/", 2)[0]
+	for _, h := range goImportKnownHosts {
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+var (
+	goImportKnownHosts = []string{"github.com", "gitlab.com", "bitbucket.org"}
+)
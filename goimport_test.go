@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+// newTestVanityServer answers a stand-in for vanity hosts, which
+// serves go-import meta tags for example.com/vanity, and counts
+// the discovery requests.
+func newTestVanityServer(t *testing.T, requests *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if r.URL.Query().Get("go-get") != "1" || !strings.HasPrefix(r.URL.Path, "/example.com/vanity") {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<html><head>
+<meta name="go-import" content="example.com/vanity git https://git.example.com/org/vanity.git">
+<meta name="go-source" content="example.com/vanity https://git.example.com/org/vanity https://git.example.com/org/vanity/tree/main{/dir} https://git.example.com/org/vanity/blob/main{/dir}/{file}#L{line}">
+</head><body>vanity</body></html>`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGoImportResolve(t *testing.T) {
+	var requests int32
+	server := newTestVanityServer(t, &requests)
+	output := t.TempDir()
+	r := newGoImportResolver(output, server.URL)
+	imp, err := r.Resolve("example.com/vanity/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imp.Prefix != "example.com/vanity" || imp.Repo() != "git.example.com/org/vanity" {
+		t.Errorf("got prefix %v repo %v", imp.Prefix, imp.Repo())
+	}
+	if imp.Source == nil || imp.Source.Home != "https://git.example.com/org/vanity" {
+		t.Errorf("got source %v", imp.Source)
+	}
+	// The cache answers paths under the prefix, and survives the resolver.
+	if _, err = newGoImportResolver(output, server.URL).Resolve("example.com/vanity/other"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("got %v requests, want 1", n)
+	}
+	if _, err = r.Resolve("example.com/missing"); err == nil {
+		t.Error("expected an error for a path without a go-import")
+	}
+}
+
+func TestGoImportResolveConcurrent(t *testing.T) {
+	var requests int32
+	server := newTestVanityServer(t, &requests)
+	r := newGoImportResolver(t.TempDir(), server.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Resolve("example.com/vanity"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("got %v requests, want 1", n)
+	}
+}
+
+func TestGoModVanityDependency(t *testing.T) {
+	var requests int32
+	server := newTestVanityServer(t, &requests)
+	output := t.TempDir()
+	p := StepParams{Cfg: Cfg{Output: output, GoVanity: true, GoVanityUrl: server.URL}}
+	p.GoImports = newGoImportResolver(output, p.Cfg.GoVanityUrl)
+	_, dep, err := makeGoModDependency(p, module.Version{Path: "example.com/vanity/sub/v2", Version: "v2.1.0"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep.Repo != "git.example.com/org/vanity" || dep.Subdir() != "sub" {
+		t.Errorf("got repo %v subdir %v", dep.Repo, dep.Subdir())
+	}
+	if got := dep.gitCheckout(); got != "tags/sub/v2.1.0" {
+		t.Errorf("got checkout %v", got)
+	}
+}
+
+func TestGoModuleDir(t *testing.T) {
+	repo := t.TempDir()
+	writeTestFile(t, filepath.Join(repo, "sub", "go.mod"), "module example.com/vanity/sub\n")
+	writeTestFile(t, filepath.Join(repo, "sub", "v2", "go.mod"), "module example.com/vanity/sub/v2\n")
+	writeTestFile(t, filepath.Join(repo, "sub", "v3", "go.mod"), "module example.com/other\n")
+	cases := []struct {
+		modPath string
+		want    string
+	}{
+		{"example.com/vanity/sub", "sub"},
+		// Major subdirectory
+		{"example.com/vanity/sub/v2", "sub/v2"},
+		// A v3 folder that isn't the module, so a major branch
+		{"example.com/vanity/sub/v3", "sub"},
+		// No folder, so a major branch
+		{"example.com/vanity/sub/v4", "sub"},
+		{"gopkg.in/yaml.v3", ""},
+	}
+	for _, c := range cases {
+		sub := ""
+		if strings.HasPrefix(c.modPath, "example.com/vanity/sub") {
+			sub = "sub"
+		}
+		if got := goModuleDir(repo, sub, c.modPath); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.modPath, got, c.want)
+		}
+	}
+}
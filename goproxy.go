@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// goProxyClient downloads modules from an endpoint that speaks
+// the GOPROXY protocol. Both http(s) and file:// urls are supported.
+type goProxyClient struct {
+	Base   string
+	Client *http.Client
+}
+
+func newGoProxyClient(base string) goProxyClient {
+	if base == "" {
+		base = defaultGoProxy
+	}
+	return goProxyClient{Base: strings.TrimSuffix(base, "/"), Client: &http.Client{Timeout: 5 * time.Minute}}
+}
+
+// goNoProxy answers true if the module must not be fetched from
+// a proxy, according to GONOPROXY, which defaults to GOPRIVATE
+// like it does for the go tool.
+func goNoProxy(modPath string) bool {
+	patterns := os.Getenv("GONOPROXY")
+	if patterns == "" {
+		patterns = os.Getenv("GOPRIVATE")
+	}
+	return module.MatchPrefixPatterns(patterns, modPath)
+}
+
+// Info answers the .info file for the module.
+func (c goProxyClient) Info(mod module.Version) ([]byte, error) {
+	return c.fetchVersion(mod, ".info")
+}
+
+// Mod answers the .mod file for the module.
+func (c goProxyClient) Mod(mod module.Version) ([]byte, error) {
+	return c.fetchVersion(mod, ".mod")
+}
+
+// Zip answers the .zip file for the module.
+func (c goProxyClient) Zip(mod module.Version) ([]byte, error) {
+	return c.fetchVersion(mod, ".zip")
+}
+
+// Latest answers the latest version of the module, the way the go
+// tool picks it: the highest release in the list, or the highest
+// pre-release if there aren't any, or what @latest answers.
+func (c goProxyClient) Latest(modPath string) (string, error) {
+	path, err := module.EscapePath(modPath)
+	if err != nil {
+		return "", err
+	}
+	b, err := c.fetch(path + "/@v/list")
+	if err != nil && !errors.Is(err, errGoProxyNotFound) {
+		return "", err
+	}
+	latest := ""
+	for _, v := range strings.Fields(string(b)) {
+		if !semver.IsValid(v) {
+			continue
+		}
+		release := semver.Prerelease(v) == ""
+		switch {
+		case latest == "":
+			latest = v
+		case release && semver.Prerelease(latest) != "":
+			latest = v
+		case release == (semver.Prerelease(latest) == "") && semver.Compare(v, latest) > 0:
+			latest = v
+		}
+	}
+	if latest != "" {
+		return latest, nil
+	}
+	// Modules without tags only have pseudo-versions.
+	if b, err = c.fetch(path + "/@latest"); err != nil {
+		return "", err
+	}
+	var info struct{ Version string }
+	if err = json.Unmarshal(b, &info); err != nil {
+		return "", fmt.Errorf("%v/@latest: %w", modPath, err)
+	}
+	return info.Version, nil
+}
+
+func (c goProxyClient) fetchVersion(mod module.Version, ext string) ([]byte, error) {
+	path, err := module.EscapePath(mod.Path)
+	if err != nil {
+		return nil, err
+	}
+	version, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return nil, err
+	}
+	return c.fetch(path + "/@v/" + version + ext)
+}
+
+// fetch answers the file at the path relative to the proxy.
+func (c goProxyClient) fetch(path string) ([]byte, error) {
+	u, err := url.Parse(c.Base)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "file" {
+		b, err := os.ReadFile(filepath.Join(filepath.FromSlash(u.Path), filepath.FromSlash(path)))
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%v: %w", path, errGoProxyNotFound)
+		}
+		return b, err
+	}
+	resp, err := c.Client.Get(c.Base + "/" + path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, fmt.Errorf("%v: %w", path, errGoProxyNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%v: %v", c.Base+"/"+path, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// goProxyDownloadPath answers the folder that holds the downloaded
+// files for the module, laid out like the module cache.
+func goProxyDownloadPath(outputFolder string, mod module.Version) (string, error) {
+	path, err := module.EscapePath(mod.Path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateFolderPath(outputFolder), "download", filepath.FromSlash(path), "@v"), nil
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+const (
+	defaultGoProxy = "https://proxy.golang.org"
+)
+
+var (
+	errGoProxyNotFound = fmt.Errorf("not found on proxy")
+)
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournaledStep is a step whose progress is recorded in the journal.
+// Completed steps are skipped on later runs, as long as their inputs
+// haven't changed.
+type JournaledStep interface {
+	Step
+	// StepId answers an id for the step that is stable
+	// between runs and unique within a run. Steps that work
+	// on a folder use the form "kind:folder".
+	StepId() string
+	// StepInputs answers everything that affects what the step
+	// does, as a struct of plain values that marshal to JSON.
+	StepInputs() interface{}
+}
+
+// journalInputs answers a hash of the inputs to a step, used to
+// detect a step that has been reconfigured since it last ran.
+func journalInputs(step JournaledStep) (string, error) {
+	b, err := json.Marshal(step.StepInputs())
+	if err != nil {
+		return "", fmt.Errorf("%v inputs: %w", step.StepId(), err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ------------------------------------------------------------
+// JOURNAL
+
+// Journal records the state of every journaled step in a run,
+// so an interrupted run can resume. It's saved after every change.
+// A nil Journal is valid and records nothing.
+type Journal struct {
+	Entries map[string]JournalEntry `json:"entries"`
+
+	path    string
+	prev    map[string]JournalEntry // The entries when the journal was loaded
+	reset   map[string]struct{}     // Entries removed from prev, because their work is being redone
+	changes map[string]JournalChange
+	mu      sync.Mutex
+}
+
+type JournalEntry struct {
+	Id       string        `json:"id"`
+	Inputs   string        `json:"inputs"`
+	Status   JournalStatus `json:"status"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Commit   string        `json:"commit,omitempty"` // The resulting commit, for steps that produce one
+	Err      string        `json:"err,omitempty"`
+}
+
+// loadJournal loads the journal from the output folder, answering
+// an empty journal if there isn't one.
+func loadJournal(outputFolder string) (*Journal, error) {
+	j := &Journal{Entries: make(map[string]JournalEntry), changes: make(map[string]JournalChange), reset: make(map[string]struct{})}
+	j.path = filepath.Join(stateFolderPath(outputFolder), "journal.json")
+	b, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		j.prev = make(map[string]JournalEntry)
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, j); err != nil {
+		return nil, fmt.Errorf("journal %v: %w", j.path, err)
+	}
+	if j.Entries == nil {
+		j.Entries = make(map[string]JournalEntry)
+	}
+	j.prev = make(map[string]JournalEntry)
+	for k, v := range j.Entries {
+		j.prev[k] = v
+	}
+	return j, nil
+}
+
+// Done answers true if the step completed in a previous
+// run with the same inputs.
+func (j *Journal) Done(id, inputs string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.prev[id]
+	return ok && e.Status == JournalDone && e.Inputs == inputs
+}
+
+// Interrupted answers true if the step was started in a
+// previous run but never completed.
+func (j *Journal) Interrupted(id string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.prev[id]
+	return ok && e.Status != JournalDone
+}
+
+// ResetFolder forgets the previous state of every step that
+// works on the folder or anything inside it, such as the clone
+// of a submodule, so they all run again.
+func (j *Journal) ResetFolder(folder string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for id := range j.prev {
+		if journalIdInFolder(id, folder) {
+			delete(j.prev, id)
+			j.reset[id] = struct{}{}
+		}
+	}
+}
+
+// journalIdInFolder answers true if the step id is for the
+// folder or a folder inside it.
+func journalIdInFolder(id, folder string) bool {
+	_, path, ok := strings.Cut(id, journalIdSeparator)
+	if !ok {
+		return false
+	}
+	return path == folder || strings.HasPrefix(path, strings.TrimRight(folder, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// Skip records that a step was skipped because it was done.
+func (j *Journal) Skip(id string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.changes[id] = JournalSkipped
+}
+
+// Start records that a step is running.
+func (j *Journal) Start(id, inputs string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	change := JournalRan
+	if _, ok := j.reset[id]; ok {
+		change = JournalRedone
+	} else if e, ok := j.prev[id]; ok {
+		if e.Status != JournalDone {
+			change = JournalRedone
+		} else if e.Inputs != inputs {
+			change = JournalChanged
+		}
+	}
+	j.changes[id] = change
+	j.Entries[id] = JournalEntry{Id: id, Inputs: inputs, Status: JournalRunning, Started: time.Now()}
+	return j.save()
+}
+
+// Finish records the result of a step.
+func (j *Journal) Finish(id string, err error) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := j.Entries[id]
+	e.Status = JournalDone
+	if err != nil {
+		e.Status = JournalFailed
+		e.Err = err.Error()
+	}
+	e.Finished = time.Now()
+	j.Entries[id] = e
+	return j.save()
+}
+
+// SetCommit records the commit that a step resulted in.
+func (j *Journal) SetCommit(id, commit string) error {
+	if j == nil || commit == "" {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := j.Entries[id]
+	e.Commit = commit
+	j.Entries[id] = e
+	return j.save()
+}
+
+// WriteReport writes what changed in this run compared to
+// the previous state of the journal.
+func (j *Journal) WriteReport(w io.Writer) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	counts := make(map[JournalChange]int)
+	var ids []string
+	for id, c := range j.changes {
+		counts[c]++
+		if c != JournalSkipped {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		e := j.Entries[id]
+		line := fmt.Sprintf("%v %v: %v", j.changes[id], id, e.Status)
+		if prev, ok := j.prev[id]; ok && e.Commit != "" && prev.Commit != "" && e.Commit != prev.Commit {
+			line += fmt.Sprintf(" (commit %v was %v)", e.Commit, prev.Commit)
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "journal: %v ran, %v redone, %v changed, %v skipped\n",
+		counts[JournalRan], counts[JournalRedone], counts[JournalChanged], counts[JournalSkipped])
+}
+
+// save writes the journal. The file is replaced atomically so
+// a crash never leaves a partial journal. Must be called locked.
+func (j *Journal) save() error {
+	if err := os.MkdirAll(filepath.Dir(j.path), os.ModePerm); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(j, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+// stateFolderPath answers the folder where guzzle keeps its own
+// data inside the output.
+func stateFolderPath(outputFolder string) string {
+	return filepath.Join(outputFolder, ".guzzle")
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+// journalIdSeparator separates the kind of step from the folder
+// it works on in step ids, i.e. "clone:/output/repo".
+const journalIdSeparator = ":"
+
+type JournalStatus string
+
+const (
+	JournalRunning JournalStatus = "running"
+	JournalDone    JournalStatus = "done"
+	JournalFailed  JournalStatus = "failed"
+)
+
+// JournalChange describes what happened to a step in this run.
+type JournalChange string
+
+const (
+	JournalRan     JournalChange = "ran"     // Never run before
+	JournalRedone  JournalChange = "redone"  // Interrupted or failed in a previous run
+	JournalChanged JournalChange = "changed" // Done in a previous run, but the inputs changed
+	JournalSkipped JournalChange = "skipped" // Done in a previous run
+)
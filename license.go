@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	classifier "github.com/google/licenseclassifier/v2"
+	"github.com/google/licenseclassifier/v2/assets"
+)
+
+// LicenseStep detects the licenses in a repo, Go module or NuGet
+// package: license and notice files are classified against the
+// SPDX license texts bundled with the classifier, and SPDX headers
+// in source files and NuGet license expressions are taken as
+// declared. It runs before thinning, which can remove license
+// files, and keeps its results in the state folder for the
+// inventory written at the end of the run.
+type LicenseStep struct {
+	Name   string // The repo, module@version or package@version
+	Kind   string // One of the License kind consts
+	Folder string
+}
+
+func (s LicenseStep) Run(p StepParams) error {
+	file, rel, ok := folderStatePath(p.Cfg.Output, "licenses", s.Folder)
+	if !ok {
+		return nil
+	}
+	fmt.Println("licenses", s.Folder)
+	found, err := detectLicenses(s.Folder)
+	if err != nil {
+		return err
+	}
+	report := LicenseReport{Name: s.Name, Kind: s.Kind, Folder: filepath.ToSlash(rel), Found: found}
+	report.Licenses = summarizeLicenses(found)
+	b, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+func (s LicenseStep) StepId() string {
+	return "licenses:" + s.Folder
+}
+
+func (s LicenseStep) StepInputs() interface{} {
+	return struct {
+		Name, Kind, Folder string
+	}{s.Name, s.Kind, s.Folder}
+}
+
+func (s LicenseStep) Plan(p StepParams) PlanNode {
+	return newPlanNode(s, "%v", s.Folder)
+}
+
+// detectLicenses answers every license found in the folder.
+func detectLicenses(folder string) ([]LicenseMatch, error) {
+	var found []LicenseMatch
+	headers := make(map[string]*LicenseMatch)
+	err := fs.WalkDir(os.DirFS(folder), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		abs := filepath.Join(folder, filepath.FromSlash(p))
+		name := d.Name()
+		switch {
+		case classifyIsLicense(name):
+			matches, err := classifyLicenseFile(abs)
+			if err != nil {
+				return err
+			}
+			if len(matches) < 1 {
+				// Still worth a look, even if it can't be classified.
+				matches = []LicenseMatch{{License: LicenseUnknown}}
+			}
+			for _, m := range matches {
+				m.File, m.Source = p, LicenseSourceFile
+				found = append(found, m)
+			}
+		case strings.EqualFold(path.Ext(name), ".nuspec"):
+			expr, err := nuspecLicense(abs)
+			if err != nil {
+				return err
+			}
+			if expr != "" {
+				found = append(found, LicenseMatch{License: expr, Confidence: 1, File: p, Source: LicenseSourceNuspec})
+			}
+		default:
+			if _, ok := classifySourceLangs[strings.ToLower(path.Ext(name))]; !ok {
+				return nil
+			}
+			expr, err := spdxHeader(abs)
+			if err != nil || expr == "" {
+				return err
+			}
+			// One entry for each license, not each file.
+			if m, ok := headers[expr]; ok {
+				m.Files++
+				return nil
+			}
+			headers[expr] = &LicenseMatch{License: expr, Confidence: 1, File: p, Source: LicenseSourceHeader, Files: 1}
+		}
+		return nil
+	})
+	for _, m := range headers {
+		found = append(found, *m)
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].File != found[j].File {
+			return found[i].File < found[j].File
+		}
+		return found[i].Confidence > found[j].Confidence
+	})
+	return found, err
+}
+
+// classifyLicenseFile answers the licenses in the file, with the
+// best confidence for each.
+func classifyLicenseFile(file string) ([]LicenseMatch, error) {
+	c, err := licenseClassifier()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, licenseMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	licenseMu.Lock()
+	results := c.Match(b)
+	licenseMu.Unlock()
+	best := make(map[string]float64)
+	for _, m := range results.Matches {
+		// Copyright notices are reported too, but aren't licenses.
+		if m.MatchType == licenseCopyright {
+			continue
+		}
+		if m.Confidence > best[m.Name] {
+			best[m.Name] = m.Confidence
+		}
+	}
+	var matches []LicenseMatch
+	for name, conf := range best {
+		matches = append(matches, LicenseMatch{License: name, Confidence: math.Round(conf*100) / 100})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Confidence != matches[j].Confidence {
+			return matches[i].Confidence > matches[j].Confidence
+		}
+		return matches[i].License < matches[j].License
+	})
+	return matches, nil
+}
+
+// licenseClassifier answers the classifier, which takes a moment
+// to load its corpus, so it's only loaded when needed.
+func licenseClassifier() (*classifier.Classifier, error) {
+	licenseOnce.Do(func() {
+		licenseCorpus, licenseErr = assets.DefaultClassifier()
+	})
+	return licenseCorpus, licenseErr
+}
+
+// spdxHeader answers the SPDX-License-Identifier near the top of
+// the file, if it has one.
+func spdxHeader(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 4096), 64*1024)
+	for i := 0; i < spdxHeaderLines && scanner.Scan(); i++ {
+		if m := spdxHeaderRe.FindStringSubmatch(scanner.Text()); m != nil {
+			return strings.TrimSpace(m[1]), nil
+		}
+	}
+	// Lines too long to scan aren't headers.
+	if err = scanner.Err(); err == bufio.ErrTooLong {
+		err = nil
+	}
+	return "", err
+}
+
+// nuspecLicense answers the license expression a NuGet package declares.
+func nuspecLicense(file string) (string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var spec struct {
+		Metadata struct {
+			License struct {
+				Type  string `xml:"type,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"license"`
+		} `xml:"metadata"`
+	}
+	if err = xml.Unmarshal(b, &spec); err != nil {
+		return "", fmt.Errorf("%v: %w", file, err)
+	}
+	if spec.Metadata.License.Type != "expression" {
+		// Packages with a license file have it classified instead.
+		return "", nil
+	}
+	return strings.TrimSpace(spec.Metadata.License.Value), nil
+}
+
+// summarizeLicenses answers each license found, with the best
+// confidence it was found with, most confident first.
+func summarizeLicenses(found []LicenseMatch) []LicenseSummary {
+	best := make(map[string]float64)
+	for _, m := range found {
+		if conf, ok := best[m.License]; !ok || m.Confidence > conf {
+			best[m.License] = m.Confidence
+		}
+	}
+	var sums []LicenseSummary
+	for name, conf := range best {
+		sums = append(sums, LicenseSummary{License: name, Confidence: conf})
+	}
+	sort.Slice(sums, func(i, j int) bool {
+		if sums[i].Confidence != sums[j].Confidence {
+			return sums[i].Confidence > sums[j].Confidence
+		}
+		return sums[i].License < sums[j].License
+	})
+	return sums
+}
+
+// writeLicenseInventory gathers the licenses detected in every
+// repo, module and package in the output into licenses.json and
+// licenses.csv.
+func writeLicenseInventory(outputFolder string) error {
+	dir := filepath.Join(stateFolderPath(outputFolder), "licenses")
+	var reports []LicenseReport
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == dir {
+			return fs.SkipDir
+		} else if err != nil || d.IsDir() || filepath.Ext(p) != ".json" {
+			return err
+		}
+		var r LicenseReport
+		b, err := os.ReadFile(p)
+		if err == nil {
+			err = json.Unmarshal(b, &r)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %w", p, err)
+		}
+		// Skip folders that have been removed since.
+		if fsExists(filepath.Join(outputFolder, filepath.FromSlash(r.Folder))) {
+			reports = append(reports, r)
+		}
+		return nil
+	})
+	if err != nil || len(reports) < 1 {
+		return err
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Kind != reports[j].Kind {
+			return licenseKindOrder[reports[i].Kind] < licenseKindOrder[reports[j].Kind]
+		}
+		return reports[i].Name < reports[j].Name
+	})
+	b, err := json.MarshalIndent(reports, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(outputFolder, licenseJson), b, 0644); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(outputFolder, licenseCsv))
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"name", "kind", "license", "confidence", "source", "file"})
+	for _, r := range reports {
+		if len(r.Found) < 1 {
+			w.Write([]string{r.Name, r.Kind, LicenseNone, "", "", ""})
+		}
+		for _, m := range r.Found {
+			w.Write([]string{r.Name, r.Kind, m.License, strconv.FormatFloat(m.Confidence, 'f', 2, 64), m.Source, m.File})
+		}
+	}
+	w.Flush()
+	return mergeErr(w.Error(), f.Close())
+}
+
+// ------------------------------------------------------------
+// TYPES
+
+// LicenseReport is the licenses detected in a single repo,
+// module or package.
+type LicenseReport struct {
+	Name     string           `json:"name"`
+	Kind     string           `json:"kind"`
+	Folder   string           `json:"folder"` // Relative to the output
+	Licenses []LicenseSummary `json:"licenses"`
+	Found    []LicenseMatch   `json:"found,omitempty"`
+}
+
+// LicenseSummary is a license detected anywhere in a folder.
+type LicenseSummary struct {
+	License    string  `json:"license"`
+	Confidence float64 `json:"confidence"`
+}
+
+// LicenseMatch is a license detected in a file.
+type LicenseMatch struct {
+	License    string  `json:"license"`    // An SPDX id or expression
+	Confidence float64 `json:"confidence"` // From 0 to 1, where declared licenses are 1
+	Source     string  `json:"source"`     // One of the LicenseSource consts
+	File       string  `json:"file"`       // Relative to the folder
+	Files      int     `json:"files,omitempty"`
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+// License kinds.
+const (
+	LicenseRepo     = "repo"
+	LicenseGoModule = "go"
+	LicenseNuGet    = "nuget"
+)
+
+// Where licenses are detected.
+const (
+	LicenseSourceFile   = "file"   // A license or notice file, classified against the corpus
+	LicenseSourceHeader = "header" // SPDX-License-Identifier headers, with the first file that has it
+	LicenseSourceNuspec = "nuspec" // A NuGet license expression
+)
+
+const (
+	LicenseUnknown = "unknown" // A license file that didn't match the corpus
+	LicenseNone    = "none"    // Nothing was found
+
+	licenseCopyright = "Copyright"
+	licenseJson      = "licenses.json"
+	licenseCsv       = "licenses.csv"
+	licenseMaxSize   = 1 << 20
+	spdxHeaderLines  = 20
+)
+
+var (
+	spdxHeaderRe = regexp.MustCompile(`SPDX-License-Identifier:\s*(.+?)\s*(?:\*/|-->|#\}|$)`)
+
+	licenseKindOrder = map[string]int{LicenseRepo: 0, LicenseGoModule: 1, LicenseNuGet: 2}
+
+	licenseOnce   sync.Once
+	licenseCorpus *classifier.Classifier
+	licenseErr    error
+	licenseMu     sync.Mutex
+)
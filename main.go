@@ -2,17 +2,13 @@ package main
 
 import (
 	"fmt"
+	"os"
 )
 
 func main() {
-	cfg, err := LoadCfgLocal("cfg.json")
-	checkErr(err)
-	output, err := run(cfg)
-	checkErr(err)
-	if len(output.Errors) > 0 {
-		fmt.Println("There were errors:")
-		for _, e := range output.Errors {
-			fmt.Println(e)
-		}
+	err := runCli(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
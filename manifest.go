@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// ManifestFile is a single file in the archive.
+type ManifestFile struct {
+	Path   string `json:"path"` // Relative to the output, with forward slashes
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	Owner  string `json:"owner,omitempty"` // The repo, dependency or module@version the file belongs to
+
+	modTime time.Time
+}
+
+// writeArchiveManifest hashes every file in the output and writes
+// the result as manifest.json and SHA256SUMS, which `sha256sum -c`
+// can also check. Files that haven't been written since the run
+// started are checked against the previous manifest first, so a
+// change made outside a run isn't accepted silently: it's answered
+// as an error, and the file keeps its previous entry.
+func writeArchiveManifest(cfg Cfg, since time.Time) ([]error, error) {
+	fmt.Println("manifest", cfg.Output)
+	files, err := hashArchive(cfg.Output)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := readArchiveManifest(cfg.Output)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	prevFiles := make(map[string]ManifestFile)
+	for _, f := range prev {
+		prevFiles[f.Path] = f
+	}
+	var errs []error
+	for i, f := range files {
+		old, ok := prevFiles[f.Path]
+		if !ok || !f.modTime.Before(since) || (f.Size == old.Size && f.Sha256 == old.Sha256) {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("manifest: %v changed outside a run, keeping its previous hash", f.Path))
+		files[i].Size, files[i].Sha256 = old.Size, old.Sha256
+	}
+	owners, err := archiveOwners(cfg)
+	if err != nil {
+		return errs, err
+	}
+	for i := range files {
+		files[i].Owner = owners.owner(files[i].Path)
+	}
+	b, err := json.MarshalIndent(files, "", "\t")
+	if err != nil {
+		return errs, err
+	}
+	if err = os.WriteFile(filepath.Join(cfg.Output, manifestJson), b, 0644); err != nil {
+		return errs, err
+	}
+	var sums strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&sums, "%v  %v\n", f.Sha256, f.Path)
+	}
+	return errs, os.WriteFile(filepath.Join(cfg.Output, manifestSums), []byte(sums.String()), 0644)
+}
+
+// readArchiveManifest reads the manifest in the output.
+func readArchiveManifest(output string) ([]ManifestFile, error) {
+	b, err := os.ReadFile(filepath.Join(output, manifestJson))
+	if err != nil {
+		return nil, err
+	}
+	var files []ManifestFile
+	if err = json.Unmarshal(b, &files); err != nil {
+		return nil, fmt.Errorf("%v: %w", manifestJson, err)
+	}
+	return files, nil
+}
+
+// verifyArchiveManifest hashes the output again and answers every
+// file that changed, went missing or was added since the manifest
+// was written.
+func verifyArchiveManifest(output string) ([]error, error) {
+	want, err := readArchiveManifest(output)
+	if err != nil {
+		return nil, err
+	}
+	got, err := hashArchive(output)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]ManifestFile)
+	for _, f := range got {
+		found[f.Path] = f
+	}
+	var errs []error
+	for _, w := range want {
+		g, ok := found[w.Path]
+		delete(found, w.Path)
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("manifest: missing %v", w.Path))
+		case g.Size != w.Size || g.Sha256 != w.Sha256:
+			errs = append(errs, fmt.Errorf("manifest: changed %v", w.Path))
+		}
+	}
+	for _, g := range got {
+		if _, ok := found[g.Path]; ok {
+			errs = append(errs, fmt.Errorf("manifest: not listed %v", g.Path))
+		}
+	}
+	return errs, nil
+}
+
+// hashArchive answers every file in the output, sorted by path.
+// The state folder and the reports guzzle writes are skipped,
+// since they change from run to run.
+func hashArchive(output string) ([]ManifestFile, error) {
+	state := filepath.Base(stateFolderPath(output))
+	var files []ManifestFile
+	err := fs.WalkDir(os.DirFS(output), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == state {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || manifestSkip[p] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size, sum, err := hashFile(filepath.Join(output, filepath.FromSlash(p)))
+		if err != nil {
+			return err
+		}
+		files = append(files, ManifestFile{Path: p, Size: size, Sha256: sum, modTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, err
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, bufio.NewReader(f))
+	return n, hex.EncodeToString(h.Sum(nil)), err
+}
+
+// archiveOwners answers the owner of each archived folder, from
+// the config and the folder metadata.
+func archiveOwners(cfg Cfg) (manifestOwners, error) {
+	owners := make(manifestOwners)
+	meta := filepath.Join(stateFolderPath(cfg.Output), "meta")
+	err := filepath.WalkDir(meta, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == meta {
+			return fs.SkipDir
+		} else if err != nil || d.IsDir() || filepath.Ext(p) != ".json" {
+			return err
+		}
+		m, err := readFolderMeta(p)
+		if err != nil {
+			return fmt.Errorf("%v: %w", p, err)
+		}
+		if m.Repo != "" {
+			owners[m.Folder] = m.Repo
+		}
+		return nil
+	})
+	if err != nil {
+		return owners, err
+	}
+	// The configured name reads better than whatever it was
+	// redirected to.
+	for _, repo := range cfg.Repos {
+		if local := cfg.LocalRepo(repo.Name); local != "" {
+			owners[filepath.ToSlash(filepath.Base(local))] = repo.Name
+		}
+	}
+	return owners, nil
+}
+
+// manifestOwners maps folders, relative to the output, to their owner.
+type manifestOwners map[string]string
+
+// owner answers the owner of the deepest folder containing the
+// file. History beside a folder belongs to the folder, and files
+// in the Go mirror belong to their module@version.
+func (o manifestOwners) owner(file string) string {
+	if strings.HasPrefix(file, manifestMirror) {
+		rest := strings.TrimPrefix(file, manifestMirror)
+		if i := strings.Index(rest, "/@v/"); i > 0 {
+			mod, err := module.UnescapePath(rest[:i])
+			ver := strings.TrimSuffix(rest[i+4:], path.Ext(rest[i+4:]))
+			if ver, verr := module.UnescapeVersion(ver); err == nil && verr == nil && ver != "list" {
+				return mod + "@" + ver
+			}
+		}
+		return ""
+	}
+	for dir := file; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if owner, ok := o[dir]; ok {
+			return owner
+		}
+		for _, ext := range []string{".bundle", ".git"} {
+			if owner, ok := o[strings.TrimSuffix(dir, ext)]; ok && strings.HasSuffix(dir, ext) {
+				return owner
+			}
+		}
+	}
+	return ""
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+const (
+	manifestJson = "manifest.json"
+	manifestSums = "SHA256SUMS"
+)
+
+var (
+	// manifestMirror is the Go mirror, relative to the output.
+	manifestMirror = filepath.ToSlash(goMirrorRoot("")) + "/"
+
+	// manifestSkip are the reports in the output.
+	manifestSkip = map[string]bool{
+		manifestJson:      true,
+		manifestSums:      true,
+		"repos.json":      true,
+		"go-modules.json": true,
+		"verify.json":     true,
+		auditJson:         true,
+		auditCsv:          true,
+		licenseJson:       true,
+		licenseCsv:        true,
+	}
+)
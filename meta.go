@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FolderMeta records where the contents of an archived folder
+// came from, since the git data is gone once it's thinned.
+type FolderMeta struct {
+	Folder        string    `json:"folder"`           // Relative to the output
+	Repo          string    `json:"repo,omitempty"`   // The repo or module@version
+	Method        string    `json:"method,omitempty"` // One of the Meta consts
+	Remote        string    `json:"remote,omitempty"` // What was actually fetched from, after fallbacks and redirects
+	Ref           string    `json:"ref,omitempty"`    // The requested ref or version
+	Head          string    `json:"head,omitempty"`   // The commit the folder contains, if known
+	Checkout      string    `json:"checkout,omitempty"`
+	CheckoutError string    `json:"checkout_error,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// updateFolderMeta changes the metadata of the folder. Folders
+// outside the output, or in its state folder, have none.
+func updateFolderMeta(p StepParams, folder string, fn func(*FolderMeta)) error {
+	if p.DryRun {
+		return nil
+	}
+	path, rel, ok := folderStatePath(p.Cfg.Output, "meta", folder)
+	if !ok {
+		return nil
+	}
+	folderMetaMu.Lock()
+	defer folderMetaMu.Unlock()
+	meta, err := readFolderMeta(path)
+	if err != nil {
+		return err
+	}
+	fn(&meta)
+	meta.Folder, meta.Time = filepath.ToSlash(rel), time.Now().UTC()
+	b, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// resetFolderMeta starts the folder's metadata over, i.e.
+// when it's cloned again.
+func resetFolderMeta(p StepParams, folder string, meta FolderMeta) error {
+	return updateFolderMeta(p, folder, func(m *FolderMeta) {
+		*m = meta
+	})
+}
+
+func readFolderMeta(path string) (FolderMeta, error) {
+	var meta FolderMeta
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return meta, nil
+	} else if err != nil {
+		return meta, err
+	}
+	return meta, json.Unmarshal(b, &meta)
+}
+
+// folderStatePath answers the file in the state folder's kind
+// folder that holds the folder's state, i.e. its metadata, and
+// the folder relative to the output.
+func folderStatePath(output, kind, folder string) (string, string, bool) {
+	rel, err := filepath.Rel(output, folder)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", "", false
+	}
+	state := filepath.Base(stateFolderPath(output))
+	if rel == state || strings.HasPrefix(rel, state+string(filepath.Separator)) {
+		return "", "", false
+	}
+	return filepath.Join(stateFolderPath(output), kind, rel+".json"), rel, true
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+// How a folder was acquired.
+const (
+	MetaClone   = "clone"
+	MetaFetch   = "fetch"   // A shallow fetch
+	MetaCache   = "cache"   // Exported from the git cache
+	MetaGoProxy = "goproxy" // Extracted from a GOPROXY zip
+	MetaCopy    = "copy"
+)
+
+// Checkout outcomes.
+const (
+	MetaCheckoutDefault = "default" // Nothing was requested, so it's the remote's default branch
+	MetaCheckoutOk      = "ok"
+	MetaCheckoutFailed  = "failed" // The folder is still at whatever was cloned
+)
+
+var folderMetaMu sync.Mutex
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// plan answers the plan for the config. Nothing is
+// written to disk and git is never called.
+func plan(cfg Cfg) ([]PlanNode, error) {
+	steps, err := buildSteps(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// The journal is only read, so the plan can skip completed steps.
+	journal, err := loadJournal(cfg.Output)
+	if err != nil {
+		return nil, err
+	}
+	p := StepParams{Cfg: cfg, Output: &StepOutput{}, Journal: journal, DryRun: true}
+	if cfg.GoVanity {
+		p.GoImports = newGoImportResolver(cfg.Output, cfg.GoVanityUrl)
+		p.GoImports.ReadOnly = true
+	}
+	p.CommonCodeFolder = commonCodePath(cfg.Output)
+	return planSteps(p, steps), nil
+}
+
+func planSteps(p StepParams, steps []Step) []PlanNode {
+	var nodes []PlanNode
+	for _, step := range steps {
+		n := step.Plan(p)
+		if js, ok := step.(JournaledStep); ok {
+			inputs, err := journalInputs(js)
+			if err != nil {
+				n.setErr(err)
+			} else if p.Journal.Done(js.StepId(), inputs) {
+				n.Skipped = true
+				n.Desc += " (done in a previous run)"
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// ------------------------------------------------------------
+// PLAN-NODE
+
+// PlanNode describes what a single step would do.
+type PlanNode struct {
+	Step     string     `json:"step"`
+	Desc     string     `json:"desc,omitempty"`
+	Skipped  bool       `json:"skipped,omitempty"` // True if the step will not run, i.e. a false condition
+	Clones   []string   `json:"clones,omitempty"`  // Remotes that will be tried, in order
+	Copies   []PlanCopy `json:"copies,omitempty"`
+	Deletes  []string   `json:"deletes,omitempty"`
+	Err      string     `json:"err,omitempty"`
+	Children []PlanNode `json:"children,omitempty"`
+}
+
+type PlanCopy struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+func newPlanNode(step Step, format string, a ...interface{}) PlanNode {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", step), "main.")
+	return PlanNode{Step: name, Desc: fmt.Sprintf(format, a...)}
+}
+
+// setErr records an error that prevented a complete plan.
+func (n *PlanNode) setErr(err error) {
+	if err != nil {
+		n.Err = err.Error()
+	}
+}
+
+// ------------------------------------------------------------
+// WRITING
+
+// writePlanJson writes the plan as JSON.
+func writePlanJson(w io.Writer, nodes []PlanNode) error {
+	b, err := json.MarshalIndent(nodes, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// writePlanTree writes the plan as a readable tree, followed
+// by a summary of everything that would be changed.
+func writePlanTree(w io.Writer, nodes []PlanNode) {
+	sum := PlanSummary{}
+	for _, n := range nodes {
+		writePlanNode(w, n, 0, false, &sum)
+	}
+	fmt.Fprintf(w, "\n%v clones, %v copies, %v deletes\n", sum.Clones, sum.Copies, sum.Deletes)
+}
+
+func writePlanNode(w io.Writer, n PlanNode, depth int, skipped bool, sum *PlanSummary) {
+	skipped = skipped || n.Skipped
+	indent := strings.Repeat("  ", depth)
+	line := indent + n.Step
+	if n.Desc != "" {
+		line += " " + n.Desc
+	}
+	if n.Skipped {
+		line += " (skipped)"
+	}
+	fmt.Fprintln(w, line)
+	for _, c := range n.Clones {
+		fmt.Fprintln(w, indent+"  clone", c)
+	}
+	for _, c := range n.Copies {
+		fmt.Fprintln(w, indent+"  copy", c.Src, "to", c.Dst)
+	}
+	for _, d := range n.Deletes {
+		fmt.Fprintln(w, indent+"  delete", d)
+	}
+	if n.Err != "" {
+		fmt.Fprintln(w, indent+"  error", n.Err)
+	}
+	if !skipped {
+		// Every clone candidate is a fallback for the same repo.
+		if len(n.Clones) > 0 {
+			sum.Clones++
+		}
+		sum.Copies += len(n.Copies)
+		sum.Deletes += len(n.Deletes)
+	}
+	for _, c := range n.Children {
+		writePlanNode(w, c, depth+1, skipped, sum)
+	}
+}
+
+type PlanSummary struct {
+	Clones  int
+	Copies  int
+	Deletes int
+}
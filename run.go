@@ -1,12 +1,19 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
-func run(cfg Cfg) (StepOutput, error) {
-	output := StepOutput{}
+func run(cfg Cfg) (*StepOutput, error) {
+	// Some filesystems only keep file times to the second.
+	start := time.Now().Truncate(time.Second)
+	output := &StepOutput{}
 	err := os.MkdirAll(cfg.Output, os.ModePerm)
 	if err != nil {
 		return output, err
@@ -15,18 +22,47 @@ func run(cfg Cfg) (StepOutput, error) {
 	if err != nil {
 		return output, err
 	}
-	p := StepParams{Cfg: cfg, Output: &output}
+	journal, err := loadJournal(cfg.Output)
+	if err != nil {
+		return output, err
+	}
+	p := StepParams{Cfg: cfg, Output: output, Shared: newKeyedOnce(), Journal: journal, Workers: newWorkerPool(cfg.Workers)}
+	if cfg.GoVanity {
+		p.GoImports = newGoImportResolver(cfg.Output, cfg.GoVanityUrl)
+	}
+	if cfg.Quarantine {
+		if p.Trash, err = newTrash(cfg.Output); err != nil {
+			return output, err
+		}
+		defer p.Trash.Close()
+		fmt.Println("quarantine deletions to", p.Trash.Dir)
+	}
 	commonCodeFolder, err := makeCommonCode(cfg.Output)
 	if err != nil {
 		return output, err
 	}
 	p.CommonCodeFolder = commonCodeFolder
-	err = runSteps(p, steps)
-	return output, err
+	err = runStepsParallel(p, steps)
+	if err == nil && cfg.Verify {
+		// Verify needs every pipeline to be finished.
+		err = runSteps(p, []Step{VerifyStep{Vet: cfg.GoVet}})
+	}
+	journal.WriteReport(os.Stdout)
+	err = mergeErr(err, writeRepoManifest(cfg, output), writeGoModuleReport(cfg.Output, output))
+	err = mergeErr(err, writeAuditReport(cfg.Output), writeLicenseInventory(cfg.Output))
+	if err == nil {
+		changed, merr := writeArchiveManifest(cfg, start)
+		output.Errors = append(output.Errors, changed...)
+		err = merr
+	} else {
+		// The archive is incomplete, so the last manifest is kept.
+		fmt.Println("run failed, manifest not written")
+	}
+	return output, mergeErr(err, pruneGitCache(cfg))
 }
 
 func makeCommonCode(outputFolder string) (string, error) {
-	dst := filepath.Join(outputFolder, "Common Code")
+	dst := commonCodePath(outputFolder)
 	err := os.MkdirAll(dst, os.ModePerm)
 	if err != nil {
 		return "", err
@@ -34,12 +70,131 @@ func makeCommonCode(outputFolder string) (string, error) {
 	return dst, nil
 }
 
+// writeRepoManifest writes every archived repo, with the
+// submodules nested in it. Repos that were done in an earlier
+// run keep the submodules recorded then.
+func writeRepoManifest(cfg Cfg, output *StepOutput) error {
+	type row struct {
+		Repo       string          `json:"repo"`
+		Folder     string          `json:"folder"`
+		Submodules []RepoSubmodule `json:"submodules,omitempty"`
+	}
+	path := filepath.Join(cfg.Output, "repos.json")
+	prev := make(map[string]row)
+	if b, err := os.ReadFile(path); err == nil {
+		var rows []row
+		if err := json.Unmarshal(b, &rows); err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+		for _, r := range rows {
+			prev[r.Repo] = r
+		}
+	}
+	for _, repo := range cfg.Repos {
+		local := cfg.LocalRepo(repo.Name)
+		if strings.HasPrefix(repo.Name, "//") || fsNotExists(local) {
+			continue
+		}
+		r, ok := prev[repo.Name]
+		if subs, found := output.Submodules[repo.Name]; found || !ok {
+			sort.Slice(subs, func(i, j int) bool {
+				return subs[i].Path < subs[j].Path
+			})
+			r.Submodules = subs
+		}
+		r.Repo, r.Folder = repo.Name, filepath.Base(local)
+		prev[repo.Name] = r
+	}
+	if len(prev) < 1 {
+		return nil
+	}
+	var rows []row
+	for _, r := range prev {
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Repo < rows[j].Repo
+	})
+	b, err := json.MarshalIndent(rows, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// writeGoModuleReport writes every Go module that was archived,
+// with the top-level repos that pulled it in, its go.sum hashes
+// and any go.sum entries that disagree with the archive.
+func writeGoModuleReport(outputFolder string, output *StepOutput) error {
+	if len(output.GoModules) < 1 {
+		return nil
+	}
+	type row struct {
+		Module     string          `json:"module"`
+		Repos      []string        `json:"repos"`
+		Hash       *GoSumHash      `json:"hash,omitempty"`
+		Mismatches []GoSumMismatch `json:"mismatches,omitempty"`
+	}
+	var rows []row
+	for key, repos := range output.GoModules {
+		sort.Strings(repos)
+		r := row{Module: key, Repos: repos}
+		if h, ok := output.GoSums[key]; ok {
+			r.Hash = &h
+		}
+		for _, m := range output.GoSumErrs {
+			if m.Module == key {
+				r.Mismatches = append(r.Mismatches, m)
+			}
+		}
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Module < rows[j].Module
+	})
+	b, err := json.MarshalIndent(rows, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputFolder, "go-modules.json"), b, 0644)
+}
+
+// commonCodePath answers the folder that holds the dependencies
+// shared between repos.
+func commonCodePath(outputFolder string) string {
+	return filepath.Join(outputFolder, "Common Code")
+}
+
 func runSteps(p StepParams, steps []Step) error {
 	for _, step := range steps {
-		err := step.Run(p)
+		err := runStep(p, step)
 		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// runStep runs a single step, recording it in the journal if it's
+// journaled. Steps completed in a previous run are skipped.
+func runStep(p StepParams, step Step) error {
+	js, ok := step.(JournaledStep)
+	if !ok || p.Journal == nil {
+		return step.Run(p)
+	}
+	id := js.StepId()
+	inputs, err := journalInputs(js)
+	if err != nil {
+		return err
+	}
+	if p.Journal.Done(id, inputs) {
+		p.Logln("skip", id, "(done in a previous run)")
+		p.Journal.Skip(id)
+		return nil
+	}
+	if err := p.Journal.Start(id, inputs); err != nil {
+		return err
+	}
+	err = step.Run(p)
+	return mergeErr(err, p.Journal.Finish(id, err))
+}
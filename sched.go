@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+)
+
+// runStepsParallel runs the steps on the run's workers. The steps
+// must be independent of each other. Once a step fails no new steps
+// are started, and the first error is answered.
+//
+// Every call shares the same workers, so a repo pipeline that runs
+// its dependencies in parallel doesn't multiply them. A step that's
+// already on a worker never waits for another one: it hands steps
+// to any that are idle and runs the rest itself.
+func runStepsParallel(p StepParams, steps []Step) error {
+	if p.Workers == nil || len(steps) <= 1 {
+		return runSteps(p, steps)
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	addErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		firstErr = mergeErr(firstErr, err)
+	}
+	for _, step := range steps {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		if p.onWorker && !p.Workers.tryAcquire() {
+			addErr(runStep(p, step))
+			continue
+		} else if !p.onWorker {
+			p.Workers.acquire()
+		}
+		wg.Add(1)
+		go func(wp StepParams, step Step) {
+			defer wg.Done()
+			defer wp.Workers.release()
+			wp.onWorker = true
+			addErr(runStep(wp, step))
+		}(p, step)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// workerPool limits how many steps run at once across the whole run.
+type workerPool struct {
+	slots chan struct{}
+}
+
+// newWorkerPool answers a pool of n workers, or nil if the run
+// is serial.
+func newWorkerPool(n int) *workerPool {
+	if n <= 1 {
+		return nil
+	}
+	return &workerPool{slots: make(chan struct{}, n)}
+}
+
+func (w *workerPool) acquire() {
+	w.slots <- struct{}{}
+}
+
+func (w *workerPool) tryAcquire() bool {
+	select {
+	case w.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *workerPool) release() {
+	<-w.slots
+}
+
+// ------------------------------------------------------------
+// PIPELINE-STEP
+
+// PipelineStep runs a named series of steps. Pipelines are
+// the unit of work that runs in parallel.
+type PipelineStep struct {
+	Name  string
+	Steps []Step
+}
+
+func (s PipelineStep) Run(p StepParams) error {
+	return runSteps(p, s.Steps)
+}
+
+func (s PipelineStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "%v", s.Name)
+	n.Children = planSteps(p, s.Steps)
+	return n
+}
+
+// ------------------------------------------------------------
+// SHARED-STEP
+
+// SharedStep performs a pipeline once per run for the key, such
+// as a folder that more than one pipeline can write to.
+type SharedStep struct {
+	Key   string
+	Steps []Step
+}
+
+func (s SharedStep) Run(p StepParams) error {
+	return p.Shared.Do(s.Key, func() error {
+		return runSteps(p, s.Steps)
+	})
+}
+
+func (s SharedStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "%v", s.Key)
+	n.Children = planSteps(p, s.Steps)
+	return n
+}
+
+// ------------------------------------------------------------
+// KEYED-ONCE
+
+// keyedOnce runs a function once per key for the life of a run.
+// Callers that arrive while the function is running wait for it,
+// and everyone receives the same error. It's used to guard folders
+// shared between pipelines, such as a dependency in common code.
+type keyedOnce struct {
+	mu    sync.Mutex
+	calls map[string]*keyedOnceCall
+}
+
+type keyedOnceCall struct {
+	done chan struct{}
+	err  error
+}
+
+func newKeyedOnce() *keyedOnce {
+	return &keyedOnce{calls: make(map[string]*keyedOnceCall)}
+}
+
+// Do runs fn if it has not been run for key. A nil keyedOnce
+// always runs fn.
+func (k *keyedOnce) Do(key string, fn func() error) error {
+	if k == nil {
+		return fn()
+	}
+	k.mu.Lock()
+	if c, ok := k.calls[key]; ok {
+		k.mu.Unlock()
+		<-c.done
+		return c.err
+	}
+	c := &keyedOnceCall{done: make(chan struct{})}
+	k.calls[key] = c
+	k.mu.Unlock()
+	defer close(c.done)
+	c.err = fn()
+	return c.err
+}
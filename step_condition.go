@@ -1,6 +1,8 @@
 package main
 
-import ()
+import (
+	"fmt"
+)
 
 // ------------------------------------------------------------
 // MACROS
@@ -21,6 +23,14 @@ func OnPathNotExists(path string, steps []Step) IfConditionStep {
 	return IfConditionStep{Condition: fn, Steps: steps}
 }
 
+// OnPathNotDone performs the steps if the path does not exist, or
+// if the journal shows any of the steps were interrupted or failed
+// in a previous run. A path with no journal history is done.
+// Interrupted work is removed before the steps are redone.
+func OnPathNotDone(path string, steps []Step) ResumeConditionStep {
+	return ResumeConditionStep{Path: path, Steps: steps}
+}
+
 // ------------------------------------------------------------
 // IF-CONDITION-STEP
 
@@ -37,6 +47,97 @@ func (s IfConditionStep) Run(p StepParams) error {
 	return runSteps(p, s.Steps)
 }
 
+func (s IfConditionStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "")
+	n.Skipped = s.Condition == nil || s.Condition() == false
+	n.Children = planSteps(p, s.Steps)
+	return n
+}
+
+// ------------------------------------------------------------
+// RESUME-CONDITION-STEP
+
+// ResumeConditionStep performs a pipeline if it hasn't completed.
+type ResumeConditionStep struct {
+	Path  string
+	Steps []Step
+}
+
+func (s ResumeConditionStep) Run(p StepParams) error {
+	if !s.needsRun(p) {
+		return nil
+	}
+	if fsExists(s.Path) {
+		fmt.Println("remove interrupted", s.Path)
+		if err := removeAll(p, s.Path, "interrupted"); err != nil {
+			return err
+		}
+	}
+	// Everything done to the old folder needs to be done again.
+	p.Journal.ResetFolder(s.Path)
+	return runSteps(p, s.Steps)
+}
+
+func (s ResumeConditionStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "%v", s.Path)
+	n.Skipped = !s.needsRun(p)
+	if !n.Skipped {
+		p.Journal.ResetFolder(s.Path)
+	}
+	n.Children = planSteps(p, s.Steps)
+	return n
+}
+
+func (s ResumeConditionStep) needsRun(p StepParams) bool {
+	if fsNotExists(s.Path) {
+		return true
+	}
+	for _, step := range s.Steps {
+		if js, ok := step.(JournaledStep); ok && p.Journal.Interrupted(js.StepId()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ------------------------------------------------------------
+// FALLBACK-STEP
+
+// FallbackStep performs a pipeline, and if it fails,
+// performs the fallback pipeline instead. If it succeeds
+// the Then pipeline is performed, whose errors are answered
+// without falling back.
+type FallbackStep struct {
+	Steps    []Step
+	Then     []Step
+	Fallback []Step
+}
+
+func (s FallbackStep) Run(p StepParams) error {
+	err := runSteps(p, s.Steps)
+	if err != nil {
+		fmt.Println("falling back after error:", err)
+		return runSteps(p, s.Fallback)
+	}
+	return runSteps(p, s.Then)
+}
+
+func (s FallbackStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "")
+	steps := newPlanNode(s, "steps")
+	steps.Children = planSteps(p, s.Steps)
+	n.Children = []PlanNode{steps}
+	if len(s.Then) > 0 {
+		then := newPlanNode(s, "on success")
+		then.Children = planSteps(p, s.Then)
+		n.Children = append(n.Children, then)
+	}
+	fallback := newPlanNode(s, "on failure")
+	fallback.Children = planSteps(p, s.Fallback)
+	n.Children = append(n.Children, fallback)
+	return n
+}
+
 // ------------------------------------------------------------
 // OR-CONDITION-STEP
 
@@ -58,6 +159,23 @@ func (s OrConditionStep) Run(p StepParams) error {
 	}
 }
 
+func (s OrConditionStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "")
+	if s.Condition == nil {
+		n.Skipped = true
+		return n
+	}
+	ok := s.Condition()
+	t := newPlanNode(s, "true")
+	t.Skipped = !ok
+	t.Children = planSteps(p, s.TrueSteps)
+	f := newPlanNode(s, "false")
+	f.Skipped = ok
+	f.Children = planSteps(p, s.FalseSteps)
+	n.Children = []PlanNode{t, f}
+	return n
+}
+
 // ------------------------------------------------------------
 // FUNCS
 
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+)
+
+// GoMirrorStep adds a module to the GOPROXY mirror in the output,
+// so the archive can be used with GOPROXY=file://<output>/cache/download.
+// It needs to run before thinning, while the module is complete.
+// Modules from a proxy are copied from the download folder, and
+// cloned modules are zipped from the git data.
+type GoMirrorStep struct {
+	Module module.Version
+	Folder string // The folder of the module's repo or download
+	Subdir string // The module's folder within the repo, see goModuleDir
+}
+
+func (s GoMirrorStep) Run(p StepParams) error {
+	dst, err := goMirrorPath(p.Cfg.Output, s.Module)
+	if err != nil {
+		return err
+	}
+	version, err := module.EscapeVersion(s.Module.Version)
+	if err != nil {
+		return err
+	}
+	fmt.Println("mirror", s.Module)
+	if err = os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	files, err := s.makeFiles(p)
+	if err == errGoMirrorNoSource || err == errGoMirrorCheckout {
+		// Not fatal, the rest of the archive is still good.
+		p.AddError(fmt.Errorf("mirror %v: %w", s.Module, err))
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, ext := range []string{".info", ".mod", ".zip"} {
+		if err = os.WriteFile(filepath.Join(dst, version+ext), files[ext], 0644); err != nil {
+			return err
+		}
+	}
+	return s.addToList(filepath.Join(dst, "list"))
+}
+
+func (s GoMirrorStep) StepId() string {
+	return "gomirror:" + s.Folder
+}
+
+func (s GoMirrorStep) StepInputs() interface{} {
+	return struct {
+		Module         module.Version
+		Folder, Subdir string
+	}{s.Module, s.Folder, s.Subdir}
+}
+
+func (s GoMirrorStep) Plan(p StepParams) PlanNode {
+	dst, err := goMirrorPath(p.Cfg.Output, s.Module)
+	n := newPlanNode(s, "%v", s.Module)
+	n.Copies = []PlanCopy{{filepath.Join(s.Folder, filepath.FromSlash(s.Subdir)), dst}}
+	n.setErr(err)
+	return n
+}
+
+// makeFiles answers the contents of the .info, .mod and .zip files.
+func (s GoMirrorStep) makeFiles(p StepParams) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	version, _ := module.EscapeVersion(s.Module.Version)
+	// Prefer what the proxy gave us.
+	if dl, err := goProxyDownloadPath(p.Cfg.Output, s.Module); err == nil {
+		for _, ext := range []string{".info", ".mod", ".zip"} {
+			if b, err := os.ReadFile(filepath.Join(dl, version+ext)); err == nil {
+				files[ext] = b
+			}
+		}
+		if len(files) == 3 {
+			return files, nil
+		}
+	}
+	// Without git data the folder might already be thinned,
+	// so there's no way to build a correct zip.
+	if fsNotExists(filepath.Join(s.Folder, ".git")) {
+		return nil, errGoMirrorNoSource
+	}
+	if err := goCheckoutFailed(p, s.Folder); err != nil {
+		return nil, err
+	}
+	sub := goModuleDir(s.Folder, s.Subdir, s.Module.Path)
+	dir := filepath.Join(s.Folder, filepath.FromSlash(sub))
+	var zip bytes.Buffer
+	err := modzip.CreateFromVCS(&zip, s.Module, s.Folder, "HEAD", sub)
+	if err != nil {
+		return nil, err
+	}
+	files[".zip"] = zip.Bytes()
+	mod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if os.IsNotExist(err) {
+		// The go tool synthesizes a go.mod for modules without one.
+		mod, err = []byte("module "+s.Module.Path+"\n"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files[".mod"] = mod
+	info := goMirrorInfo{Version: s.Module.Version, Time: gitCommitTime(s.Folder)}
+	if files[".info"], err = json.Marshal(info); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// addToList adds the version to the list file. Pseudo-versions
+// are not listed, as per the GOPROXY protocol.
+func (s GoMirrorStep) addToList(path string) error {
+	if module.IsPseudoVersion(s.Module.Version) {
+		return nil
+	}
+	goMirrorListMu.Lock()
+	defer goMirrorListMu.Unlock()
+	versions := []string{s.Module.Version}
+	if b, err := os.ReadFile(path); err == nil {
+		for _, v := range strings.Fields(string(b)) {
+			if v != s.Module.Version {
+				versions = append(versions, v)
+			}
+		}
+	}
+	sort.Strings(versions)
+	return os.WriteFile(path, []byte(strings.Join(versions, "\n")+"\n"), 0644)
+}
+
+// goMirrorMod adds the go.mod of a module to the mirror, if it
+// isn't there already. The go tool reads the go.mod of every
+// version in the module graph, not just the selected ones.
+func goMirrorMod(outputFolder string, mod module.Version, b []byte) error {
+	dst, err := goMirrorPath(outputFolder, mod)
+	if err != nil {
+		return err
+	}
+	version, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dst, version+".mod")
+	if fsExists(path) {
+		return nil
+	}
+	if err = os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// goMirrorPath answers the @v folder for the module in the mirror.
+func goMirrorPath(outputFolder string, mod module.Version) (string, error) {
+	path, err := module.EscapePath(mod.Path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(goMirrorRoot(outputFolder), filepath.FromSlash(path), "@v"), nil
+}
+
+// goMirrorRoot answers the root of the GOPROXY mirror.
+func goMirrorRoot(outputFolder string) string {
+	return filepath.Join(outputFolder, "cache", "download")
+}
+
+// gitCommitTime answers the time of the HEAD commit in the folder,
+// or nil if it can't be determined.
+func gitCommitTime(folder string) *time.Time {
+	cmd := exec.Command("git", "log", "-1", "--format=%cI")
+	cmd.Dir = folder
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// goMirrorInfo is the contents of a .info file.
+type goMirrorInfo struct {
+	Version string
+	Time    *time.Time `json:",omitempty"`
+}
+
+// goCheckoutFailed answers errGoMirrorCheckout if the folder's
+// checkout failed, which leaves some other version in it that
+// would be mirrored or hashed under this version.
+func goCheckoutFailed(p StepParams, folder string) error {
+	path, _, ok := folderStatePath(p.Cfg.Output, "meta", folder)
+	if !ok {
+		return nil
+	}
+	meta, err := readFolderMeta(path)
+	if err != nil {
+		return err
+	}
+	if meta.Checkout == MetaCheckoutFailed {
+		return errGoMirrorCheckout
+	}
+	return nil
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+// goMirrorListMu guards the list files, which are shared by
+// every version of a module.
+var goMirrorListMu sync.Mutex
+
+var (
+	errGoMirrorNoSource = fmt.Errorf("no download or git data, reclone the dependency to mirror it")
+	errGoMirrorCheckout = fmt.Errorf("the version could not be checked out, so the folder holds another version")
+)
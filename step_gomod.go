@@ -1,13 +1,19 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
 )
 
 // GoModStep finds and clones dependencies for Go go.mod files.
@@ -33,109 +39,323 @@ func (s GoModStep) Run(p StepParams) error {
 	return s.processDependencies(p, deps)
 }
 
-// gatherMods gathers all the go.mod files.
+// Plan answers the dependency pipelines. Dependencies can only
+// be known if the repo has already been cloned.
+func (s GoModStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "%v", s.LocalFolder)
+	if fsNotExists(s.LocalFolder) {
+		n.Desc += " (dependencies are found after clone)"
+		return n
+	}
+	// Errors found while planning are part of the plan.
+	planOutput := &StepOutput{}
+	p.Output = planOutput
+	mods, err := s.gatherMods()
+	if err == nil {
+		var deps map[string]GoModDependency
+		deps, err = s.gatherDependencies(p, mods)
+		if err == nil && len(planOutput.Errors) > 0 {
+			var msgs []string
+			for _, e := range planOutput.Errors {
+				msgs = append(msgs, e.Error())
+			}
+			err = fmt.Errorf("%v", strings.Join(msgs, "; "))
+		}
+		n.Children = planSteps(p, s.makeDependencyStepList(p, deps))
+	}
+	n.setErr(err)
+	return n
+}
+
+// gatherMods gathers all the go.mod files, skipping
+// folders that the go tool ignores.
 func (s GoModStep) gatherMods() ([]string, error) {
-	var sums []string
+	var mods []string
 	f := os.DirFS(s.LocalFolder)
 	fs.WalkDir(f, ".", func(path string, d fs.DirEntry, err error) error {
-		if path == "." {
+		if path == "." || err != nil {
 			return nil
 		}
+		if d.IsDir() && goIgnoredDir(d.Name()) {
+			return fs.SkipDir
+		}
 		base := filepath.Base(path)
 		if base == "go.mod" {
-			sums = append(sums, path)
+			mods = append(mods, path)
 		}
 		return nil
 	})
-	return sums, nil
+	return mods, nil
 }
 
-// gatherDependencies finds all dependencies for the mod files.
-func (s GoModStep) gatherDependencies(p StepParams, sums []string) (map[string]GoModDependency, error) {
+// gatherDependencies finds all dependencies for the mod files,
+// honouring replace and exclude directives.
+func (s GoModStep) gatherDependencies(p StepParams, mods []string) (map[string]GoModDependency, error) {
 	deps := make(map[string]GoModDependency)
 	f := os.DirFS(s.LocalFolder)
-	for _, path := range sums {
+	for _, path := range mods {
 		b, err := fsReadBytes(f, path)
 		if err != nil {
 			return nil, err
 		}
-		lines, err := s.getRequireBlock(b)
+		file, err := modfile.Parse(path, b, nil)
+		if err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(s.LocalFolder, filepath.Dir(path))
+		reqs := file.Require
+		if p.Cfg.GoTransitive || s.Repo.GoTransitive {
+			reqs, err = s.gatherTransitive(p, path, file)
+			if err != nil {
+				return nil, err
+			}
+		}
+		sum, err := readGoSum(filepath.Join(dir, "go.sum"))
 		if err != nil {
 			return nil, err
 		}
-		for _, line := range lines {
-			key, dep := makeGoModDependency(p, line)
+		for _, r := range reqs {
+			if goModExcluded(file, r.Mod) {
+				// The go tool uses the next available version, which
+				// we have no way of knowing.
+				p.AddError(fmt.Errorf("%v requires excluded %v, the next version will not be archived", path, r.Mod))
+				continue
+			}
+			raw := r.Mod.String()
+			rep := goModReplacement(file, r.Mod)
+			var key string
+			var dep GoModDependency
+			if rep == nil {
+				key, dep, err = makeGoModDependency(p, r.Mod, raw)
+				dep.Proxy = s.useProxy(p)
+				key = dep.key(key)
+			} else if modfile.IsDirectoryPath(rep.New.Path) {
+				local := filepath.Clean(filepath.Join(dir, filepath.FromSlash(rep.New.Path)))
+				// Folders inside the repo are archived with it.
+				if rel, err := filepath.Rel(s.LocalFolder, local); err == nil && !strings.HasPrefix(rel, "..") {
+					continue
+				}
+				key, dep = makeGoModLocalDependency(r.Mod, local, raw+" => "+rep.New.Path)
+			} else {
+				key, dep, err = makeGoModDependency(p, rep.New, raw+" => "+rep.New.String())
+				dep.Proxy = s.useProxy(p)
+				key = dep.key(key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", path, err)
+			}
+			dep.Indirect = r.Indirect
+			dep.Sum, dep.ModSum = sum[dep.ModuleVersion()], sum[dep.ModuleVersion()+"/go.mod"]
 			deps[key] = dep
 		}
 	}
 	return deps, nil
 }
 
-func (s GoModStep) getRequireBlock(fileBytes []byte) ([]string, error) {
-	scanner := bufio.NewScanner(bytes.NewReader(fileBytes))
-	inRequireBlock := false
-	var lines []string
-	for scanner.Scan() {
-		line := scanner.Text()
-		if inRequireBlock {
-			if line == ")" {
-				return lines, nil
-			}
-			lines = append(lines, line)
-		} else {
-			if line == "require (" {
-				inRequireBlock = true
-			} else if strings.HasPrefix(line, "require ") {
-				lines = append(lines, strings.TrimPrefix(line, "require "))
-			}
+// useProxy answers true if dependencies are downloaded from a
+// GOPROXY. The repo setting wins over the config.
+func (s GoModStep) useProxy(p StepParams) bool {
+	acquire := s.Repo.GoAcquire
+	if acquire == "" {
+		acquire = p.Cfg.GoAcquire
+	}
+	return acquire == GoAcquireProxy
+}
+
+// gatherTransitive answers the full build list of the go.mod file,
+// cross-checked against its go.sum. Requirements not listed in the
+// go.mod are answered as indirect.
+func (s GoModStep) gatherTransitive(p StepParams, path string, file *modfile.File) ([]*modfile.Require, error) {
+	fmt.Println("resolve module graph", filepath.Join(s.LocalFolder, path))
+	g := newGoModGraph(p, s, file)
+	list, err := g.buildList()
+	if err != nil {
+		return nil, err
+	}
+	if len(g.missing) > 0 {
+		// Only possible while planning
+		p.AddError(fmt.Errorf("%v: module graph is incomplete, %v go.mod files have not been fetched", path, len(g.missing)))
+	}
+	if p.Cfg.GoMirror && !p.DryRun {
+		if err = g.mirrorMods(); err != nil {
+			return nil, err
+		}
+	}
+	sumPath := filepath.Join(s.LocalFolder, filepath.Dir(path), "go.sum")
+	sum, err := readGoSum(sumPath)
+	if err != nil {
+		return nil, err
+	}
+	if fsNotExists(sumPath) {
+		// Every module would be missing from it.
+		if len(list) > 0 {
+			p.AddError(fmt.Errorf("%v: no go.sum", path))
+		}
+	} else {
+		for _, msg := range sum.crossCheck(list) {
+			p.AddError(fmt.Errorf("%v: %v", path, msg))
 		}
 	}
-	if len(lines) > 0 {
-		return lines, nil
+	direct := make(map[string]bool)
+	for _, r := range file.Require {
+		direct[r.Mod.Path] = !r.Indirect
 	}
-	return lines, fmt.Errorf("no require block in go.mod")
+	var reqs []*modfile.Require
+	for _, mod := range list {
+		reqs = append(reqs, &modfile.Require{Mod: mod, Indirect: !direct[mod.Path]})
+	}
+	return reqs, nil
 }
 
 // processDependencies processes the dependency lists, which
 // means optionally cloning the repo, and then thinning the data.
+// Dependencies run in parallel, and each dependency folder is only
+// processed once per run, no matter how many repos require it.
 func (s GoModStep) processDependencies(p StepParams, deps map[string]GoModDependency) error {
+	return runStepsParallel(p, s.makeDependencyStepList(p, deps))
+}
+
+// makeDependencyStepList answers a step per dependency folder.
+// Modules cloned from the same repo at the same version share a
+// folder, so they're hashed and mirrored in one pipeline, before
+// the folder is thinned.
+func (s GoModStep) makeDependencyStepList(p StepParams, deps map[string]GoModDependency) []Step {
+	var steps []goModDependencyStep
+	clones := make(map[string]int)
+	for _, key := range sortedDependencyKeys(deps) {
+		dep := deps[key]
+		if dep.LocalPath == "" && !dep.Proxy {
+			folder := s.dependencyFolder(p, dep)
+			if i, ok := clones[folder]; ok {
+				steps[i].Also = append(steps[i].Also, dep)
+				continue
+			}
+			clones[folder] = len(steps)
+		}
+		steps = append(steps, goModDependencyStep{GoModStep: s, Key: key, Dep: dep})
+	}
+	var ans []Step
+	for _, step := range steps {
+		ans = append(ans, step)
+	}
+	return ans
+}
+
+// makeDependencySteps answers the pipeline for a single dependency,
+// along with any other modules cloned to the same folder.
+func (s GoModStep) makeDependencySteps(p StepParams, dep GoModDependency, also ...GoModDependency) []Step {
 	dst := p.CommonCodeFolder
-	for key, dep := range deps {
-		if !strings.Contains(key, `genproto`) {
-			// continue
+	folder := s.dependencyFolder(p, dep)
+	if dep.LocalPath != "" {
+		// Local replacements are copied
+		steps := []Step{OnPathNotDone(folder, []Step{CopyStep{dep.LocalPath, filepath.Dir(folder)}})}
+		return append(steps, s.makeThinningSteps(p, dep, folder)...)
+	}
+	if dep.Proxy && s.Repo.RepoCopyFrom(dep.Repo) == nil {
+		// Download from the proxy, falling back to a clone if
+		// the download fails.
+		mod := module.Version{Path: dep.Module, Version: dep.Version.SumVersion}
+		download := []Step{OnPathNotDone(folder, []Step{GoProxyStep{Module: mod, Folder: folder}})}
+		then := []Step{GoModRetractStep{Dep: dep, Folder: folder}}
+		then = append(then, s.makeMirrorSteps(p, dep, folder)...)
+		then = append(then, s.makeSumSteps(p, dep, folder)...)
+		then = append(then, s.makeThinningSteps(p, dep, folder)...)
+		dep.Proxy = false
+		fallback := s.makeDependencySteps(p, dep)
+		if clone := s.dependencyFolder(p, dep); clone != folder {
+			// Other modules in the same repo can fall back to the same clone.
+			fallback = []Step{SharedStep{Key: clone, Steps: fallback}}
 		}
-		remote := dep.Repo
-		folder := filepath.Join(dst, dep.Repo+versionSeparator+dep.Version.id)
-		checkout := dep.Version.gitCheckout()
-		// Clone if needed
-		steps := s.makeCloneSteps(p, dep.Repo, dst, remote, folder, checkout)
-		// Thin
-		steps = append(steps, s.makeThinningSteps(p, folder)...)
-		err := runSteps(p, steps)
-		if err != nil {
-			err = wrapErr(err, fmt.Sprintf("key %v go.mod %v to %v from repo %v", key, dep.Raw, folder, s.Repo.Name))
-			// Useful if you want everyone to complete and see the final errors
-			// p.AddError(err)
-			return err
+		return []Step{FallbackStep{Steps: download, Then: then, Fallback: fallback}}
+	}
+	// Clone if needed
+	steps := s.makeCloneSteps(p, dep, dst, folder)
+	for _, d := range append([]GoModDependency{dep}, also...) {
+		// Report retractions while the go.mod is available
+		steps = append(steps, GoModRetractStep{Dep: d, Folder: folder})
+		// Mirror and hash while the module is complete
+		steps = append(steps, s.makeMirrorSteps(p, d, folder)...)
+		steps = append(steps, s.makeSumSteps(p, d, folder)...)
+	}
+	// Thin
+	return append(steps, s.makeThinningSteps(p, dep, folder)...)
+}
+
+// makeMirrorSteps answers the steps to add the dependency
+// to the GOPROXY mirror, if there is one.
+func (s GoModStep) makeMirrorSteps(p StepParams, dep GoModDependency, folder string) []Step {
+	// Copied dependencies come from elsewhere, and have no version.
+	if !p.Cfg.GoMirror || dep.LocalPath != "" || s.Repo.RepoCopyFrom(dep.Repo) != nil {
+		return nil
+	}
+	mod := module.Version{Path: dep.Module, Version: dep.Version.SumVersion}
+	return []Step{GoMirrorStep{Module: mod, Folder: folder, Subdir: dep.Subdir()}}
+}
+
+// makeSumSteps answers the steps to hash the dependency
+// for go.sum verification.
+func (s GoModStep) makeSumSteps(p StepParams, dep GoModDependency, folder string) []Step {
+	if dep.LocalPath != "" || s.Repo.RepoCopyFrom(dep.Repo) != nil {
+		return nil
+	}
+	mod := module.Version{Path: dep.Module, Version: dep.Version.SumVersion}
+	return []Step{GoSumStep{Module: mod, Folder: folder, Subdir: dep.Subdir()}}
+}
+
+// dependencyFolder answers the common code folder for the dependency.
+func (s GoModStep) dependencyFolder(p StepParams, dep GoModDependency) string {
+	if dep.LocalPath != "" {
+		return filepath.Join(p.CommonCodeFolder, goModLocalFolder, goModLocalName(dep.LocalPath))
+	} else if dep.Proxy {
+		return filepath.Join(p.CommonCodeFolder, filepath.FromSlash(dep.ModuleVersion()))
+	}
+	return filepath.Join(p.CommonCodeFolder, dep.Repo+versionSeparator+dep.versionId())
+}
+
+// archivedDependency answers the dependency and its folder in common
+// code, whether it was downloaded or cloned, or an empty folder if it
+// hasn't been archived.
+func (s GoModStep) archivedDependency(p StepParams, dep GoModDependency) (GoModDependency, string) {
+	for _, proxy := range []bool{true, false} {
+		dep.Proxy = proxy
+		if folder := s.dependencyFolder(p, dep); fsExists(folder) {
+			return dep, folder
 		}
 	}
-	return nil
+	dep.Proxy = false
+	return dep, ""
 }
 
 // makeCloneSteps answers a pipeline for cloning the repo
 // (or copying it if there's a copy rule).
-func (s GoModStep) makeCloneSteps(p StepParams, depRepo, commonCode, remote, folder, checkout string) []Step {
+func (s GoModStep) makeCloneSteps(p StepParams, dep GoModDependency, commonCode, folder string) []Step {
 	// Copy if there's a copy rule for this repo
-	copy := s.Repo.RepoCopyFrom(depRepo)
+	copy := s.Repo.RepoCopyFrom(dep.Repo)
 	if copy != nil {
-		return s.makeCopySteps(p, *copy, depRepo, commonCode, remote, folder)
+		return s.makeCopySteps(p, *copy, dep.Repo, commonCode, dep.Repo, folder)
 	}
 	// Clone if needed
-	steps := []Step{CloneStep{remote, folder}, CheckoutStep{folder, checkout}}
-	steps = []Step{OnPathNotExists(folder, steps)}
+	steps := []Step{OnPathNotDone(folder, s.makeGitSteps(p, dep, folder))}
 	return steps
 }
 
+// makeGitSteps answers the steps to clone the dependency and
+// check out its version. Shallow dependencies only fetch the
+// version, and sparse dependencies in a subdirectory only
+// fetch and check out that folder.
+func (s GoModStep) makeGitSteps(p StepParams, dep GoModDependency, folder string) []Step {
+	clone := CloneStep{Repo: dep.Repo, LocalFolder: folder}
+	checkout := CheckoutStep{LocalFolder: folder, Commit: dep.gitCheckout()}
+	if p.Cfg.GoShallow || s.Repo.GoShallow {
+		clone.Fetch.Ref = checkout.Commit
+	}
+	if sub := dep.Subdir(); sub != "" && (p.Cfg.GoSparse || s.Repo.GoSparse) {
+		clone.Fetch = VcsFetch{Ref: checkout.Commit, Filter: "blob:none"}
+		checkout.Sparse = []string{sub}
+	}
+	return []Step{clone, checkout}
+}
+
 // makeCopySteps answers steps a pipeline for copying the
 // repo from a local folder.
 func (s GoModStep) makeCopySteps(p StepParams, copy RepoCopy, depRepo, commonCode, remote, folder string) []Step {
@@ -177,40 +397,359 @@ func (s GoModStep) makeCopySrcDst(src, dst string) (string, string, bool) {
 	panic("unhandled copy paths src " + src + " dst " + dst)
 }
 
-func (s GoModStep) makeThinningSteps(p StepParams, folder string) []Step {
+func (s GoModStep) makeThinningSteps(p StepParams, dep GoModDependency, folder string) []Step {
 	// Audit
-	//	return []Step{AuditStep{Folder: folder}}
+	before := AuditReportStep{Name: dep.ModuleVersion(), Kind: AuditDependency, Folder: folder, Stage: AuditBefore}
+	after := before
+	after.Stage = AuditAfter
+	// Dependencies can be shared between repos, so only the
+	// config's rules apply.
+	steps := []Step{LfsStep{Folder: folder, Fetch: p.Cfg.Lfs}, before}
+	if p.Cfg.Licenses {
+		steps = append(steps, LicenseStep{Name: dep.ModuleVersion(), Kind: LicenseGoModule, Folder: folder})
+	}
 	empty := DeleteEmptyFoldersStep{Folder: folder, IncludeGit: true}
-	return []Step{DeleteGitStep{Folder: folder}, empty}
+	return append(steps, DeleteGitStep{Folder: folder, Rules: p.Cfg.Thin}, empty, after)
+}
+
+// ------------------------------------------------------------
+// GO-MOD-DEPENDENCY-STEP
+
+// goModDependencyStep runs the pipeline for a single dependency.
+type goModDependencyStep struct {
+	GoModStep
+	Key  string
+	Dep  GoModDependency
+	Also []GoModDependency // Other modules cloned to the same folder
+}
+
+func (s goModDependencyStep) Run(p StepParams) error {
+	folder := s.dependencyFolder(p, s.Dep)
+	deps := append([]GoModDependency{s.Dep}, s.Also...)
+	for _, dep := range deps {
+		p.Output.AddGoModule(dep.ModuleVersion(), s.Repo.Name)
+	}
+	err := p.Shared.Do(folder, func() error {
+		return runSteps(p, s.makeDependencySteps(p, s.Dep, s.Also...))
+	})
+	if err != nil {
+		err = wrapErr(err, fmt.Sprintf("key %v go.mod %v to %v from repo %v", s.Key, s.Dep.Raw, folder, s.Repo.Name))
+		// Useful if you want everyone to complete and see the final errors
+		// p.AddError(err)
+		return err
+	}
+	for _, dep := range deps {
+		s.checkSums(p, dep)
+	}
+	return nil
+}
+
+// checkSums compares the go.sum entries of this repo with the
+// hashes of the archived module. Every repo that requires the
+// module is checked, even though it's only archived once.
+func (s goModDependencyStep) checkSums(p StepParams, dep GoModDependency) {
+	key := dep.ModuleVersion()
+	h, ok := p.Output.GoSumHash(key)
+	if !ok {
+		return
+	}
+	check := func(file, expected, archived string) {
+		if expected == "" || expected == archived {
+			return
+		}
+		m := GoSumMismatch{Module: key, Repo: s.Repo.Name, File: file, Expected: expected, Archived: archived}
+		p.Output.AddGoSumMismatch(m)
+		p.AddError(fmt.Errorf("go.sum mismatch for %v %v in %v: expected %v, archived %v (wrong tag, moved tag or fork?)", key, file, s.Repo.Name, expected, archived))
+	}
+	check("zip", dep.Sum, h.Zip)
+	check("go.mod", dep.ModSum, h.Mod)
+}
+
+func (s goModDependencyStep) Plan(p StepParams) PlanNode {
+	n := PlanNode{Step: "GoModDependency", Desc: s.Key}
+	n.Children = planSteps(p, s.makeDependencySteps(p, s.Dep, s.Also...))
+	return n
+}
+
+// ------------------------------------------------------------
+// GO-MOD-RETRACT-STEP
+
+// GoModRetractStep reports if the version of the dependency that
+// was required is retracted. Retractions are published in the
+// go.mod of the module's latest version, which comes from the
+// GOPROXY when one is in use. Otherwise, and for modules the
+// proxy doesn't know, it falls back to the acquired go.mod.
+type GoModRetractStep struct {
+	Dep    GoModDependency
+	Folder string
+}
+
+func (s GoModRetractStep) Run(p StepParams) error {
+	path, b, err := s.latestMod(p)
+	if err != nil {
+		p.Logln("no latest go.mod for", s.Dep.Module, "from the proxy:", err)
+		path = filepath.Join(s.Folder, filepath.FromSlash(s.Dep.ModuleDir(s.Folder)), "go.mod")
+		if b, err = os.ReadFile(path); err != nil {
+			// Not every dependency has a go.mod
+			return nil
+		}
+	}
+	file, err := modfile.ParseLax(path, b, nil)
+	if err != nil {
+		p.AddError(err)
+		return nil
+	}
+	for _, r := range file.Retract {
+		if goModVersionInRange(s.Dep.Version.SumVersion, r.VersionInterval) {
+			msg := s.Dep.Raw + " is retracted"
+			if r.Rationale != "" {
+				msg += ": " + r.Rationale
+			}
+			p.AddError(fmt.Errorf("%v", msg))
+		}
+	}
+	return nil
+}
+
+// latestMod answers the go.mod of the module's latest version.
+func (s GoModRetractStep) latestMod(p StepParams) (string, []byte, error) {
+	// Only ask a proxy the config asked for, and never for private modules.
+	if p.Cfg.GoProxy == "" && !s.Dep.Proxy {
+		return "", nil, fmt.Errorf("no go_proxy")
+	} else if goNoProxy(s.Dep.Module) {
+		return "", nil, fmt.Errorf("%v matches GONOPROXY", s.Dep.Module)
+	}
+	// Don't leak the names of redirected modules to a public proxy.
+	if _, ok := p.Cfg.MatchRedirect(s.Dep.Module); ok && p.Cfg.GoProxy == "" {
+		return "", nil, fmt.Errorf("%v is redirected", s.Dep.Module)
+	} else if _, ok := p.Cfg.MatchRedirect(makeGoModRepo(s.Dep.Module)); ok && p.Cfg.GoProxy == "" {
+		return "", nil, fmt.Errorf("%v is redirected", s.Dep.Module)
+	}
+	client := newGoProxyClient(p.Cfg.GoProxy)
+	latest, err := client.Latest(s.Dep.Module)
+	if err != nil {
+		return "", nil, err
+	}
+	mod := module.Version{Path: s.Dep.Module, Version: latest}
+	b, err := client.Mod(mod)
+	return mod.String() + "/go.mod", b, err
+}
+
+func (s GoModRetractStep) Plan(p StepParams) PlanNode {
+	return newPlanNode(s, "%v", s.Dep.Raw)
 }
 
 // ------------------------------------------------------------
 // TYPES
 
 type GoModDependency struct {
-	Repo    string
-	Version GoModVersion // The go.mod version.
-	Raw     string       // The raw line from go.sum
-}
-
-// makeGoModDependency creates a dependency from a line in the
-// go.mod file.
-func makeGoModDependency(p StepParams, raw string) (string, GoModDependency) {
-	// There need to be at least two fields, and the second
-	// needs to start with "v"
-	fields := strings.Fields(raw)
-	if len(fields) < 2 {
-		panic("invalid go.mod entry:" + raw)
-	}
-	repo := fields[0]
-	redirect := p.Cfg.GetRedirect(repo)
-	if redirect != repo {
+	Module     string // The module path, after replacement
+	Repo       string
+	RepoPrefix string       // The module path prefix at the repo root, if it isn't Repo, i.e. for vanity paths
+	RepoSubdir string       // The folder in the repo for RepoPrefix, from go-import discovery
+	Version    GoModVersion // The go.mod version.
+	Indirect   bool         // True if the requirement is marked // indirect
+	LocalPath  string       // The folder of a local replacement, which is copied instead of cloned
+	Proxy      bool         // True if the module is downloaded from a GOPROXY instead of cloned
+	Sum        string       // The h1: hash of the module from go.sum
+	ModSum     string       // The h1: hash of the module's go.mod from go.sum
+	Raw        string       // The requirement from go.mod, including any replacement
+}
+
+// key answers the dependency's key in a set of dependencies,
+// given the key for its repo. The repo can hold more than one
+// module at the same version, such as a /v2 or nested module,
+// so those include the module path.
+func (d GoModDependency) key(repoKey string) string {
+	if d.Proxy {
+		return d.ModuleVersion()
+	} else if d.Module != d.Repo {
+		return repoKey + " " + d.Module
+	}
+	return repoKey
+}
+
+// ModuleVersion answers the module@version of the dependency,
+// or just the module for local replacements.
+func (d GoModDependency) ModuleVersion() string {
+	if d.LocalPath != "" {
+		return d.Module
+	}
+	return d.Module + versionSeparator + d.Version.SumVersion
+}
+
+// gitCheckout answers the git checkout string for the dependency.
+// Modules in a subfolder of their repo have the folder as a tag prefix.
+func (d GoModDependency) gitCheckout() string {
+	if sub := d.Subdir(); sub != "" && d.Version.Type == GoModVersionTag {
+		return "tags/" + sub + "/" + d.Version.id
+	}
+	return d.Version.gitCheckout()
+}
+
+// versionId answers the unique portion of the version. Tags of
+// modules in a subfolder are prefixed, since the repo can hold
+// several modules with the same version.
+func (d GoModDependency) versionId() string {
+	if sub := d.Subdir(); sub != "" && d.Version.Type == GoModVersionTag {
+		return strings.ReplaceAll(sub, "/", "-") + "-" + d.Version.id
+	}
+	return d.Version.id
+}
+
+// Subdir answers the folder of the module inside its repo,
+// or an empty string if the module is at the root. Major version
+// suffixes aren't included, since tags never have them, but the
+// module can still be in a major subdirectory, see ModuleDir.
+func (d GoModDependency) Subdir() string {
+	if d.Proxy {
+		return ""
+	}
+	root := d.Repo
+	if d.RepoPrefix != "" {
+		root = d.RepoPrefix
+	}
+	// Major versions are either a folder or a branch, but the
+	// tags never include them.
+	modPath := d.Module
+	if prefix, _, ok := module.SplitPathVersion(modPath); ok {
+		modPath = prefix
+	}
+	if !strings.HasPrefix(modPath, root+"/") {
+		return d.RepoSubdir
+	}
+	sub := strings.TrimPrefix(modPath, root+"/")
+	return path.Join(d.RepoSubdir, sub)
+}
+
+// ModuleDir answers the folder of the module inside its repo,
+// once the repo is in folder.
+func (d GoModDependency) ModuleDir(folder string) string {
+	if d.Proxy {
+		return ""
+	}
+	return goModuleDir(folder, d.Subdir(), d.Module)
+}
+
+// goModuleDir answers the folder of the module in the repo at folder,
+// given its Subdir. Modules with a major version are either in the
+// Subdir on a major branch, or in a major subdirectory, sub/vN, which
+// the go tool prefers if its go.mod declares the module.
+func goModuleDir(folder, sub, modPath string) string {
+	if major := goModMajorDir(sub, modPath); major != "" {
+		b, err := os.ReadFile(filepath.Join(folder, filepath.FromSlash(major), "go.mod"))
+		if err == nil && modfile.ModulePath(b) == modPath {
+			return major
+		}
+	}
+	return sub
+}
+
+// goModMajorDir answers the major subdirectory of the module,
+// or an empty string if its path has no major version.
+func goModMajorDir(sub, modPath string) string {
+	_, major, ok := module.SplitPathVersion(modPath)
+	if !ok || !strings.HasPrefix(major, "/") {
+		// gopkg.in versions are always branches
+		return ""
+	}
+	return path.Join(sub, major[1:])
+}
+
+// sortedDependencyKeys answers the keys of deps in a stable order.
+func sortedDependencyKeys(deps map[string]GoModDependency) []string {
+	var keys []string
+	for k := range deps {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// makeGoModDependency creates a dependency from a module
+// requirement in the go.mod file.
+func makeGoModDependency(p StepParams, mod module.Version, raw string) (string, GoModDependency, error) {
+	dep := GoModDependency{Module: mod.Path, Raw: raw}
+	repo := mod.Path
+	root := makeGoModRepo(repo)
+	if redirect, ok := p.Cfg.MatchRedirect(repo); ok {
 		repo = redirect
+	} else if redirect, ok := p.Cfg.MatchRedirect(root); ok && root != repo {
+		// The module is in a redirected repo.
+		repo, dep.RepoPrefix = redirect, root
+	} else if p.GoImports != nil && !goImportKnownHost(repo) {
+		// Vanity paths need discovery to find the real repo.
+		imp, err := p.GoImports.Resolve(repo)
+		if err == nil {
+			repo, dep.RepoPrefix, dep.RepoSubdir = p.Cfg.GetRedirect(imp.Repo()), imp.Prefix, imp.Subdir
+		} else {
+			p.AddError(err)
+			repo = root
+		}
 	} else {
-		repo = makeGoModRepo(repo)
+		repo = root
+	}
+	dep.Repo = repo
+	version, err := makeGoModVersion(mod.Version)
+	if err != nil {
+		return "", dep, err
 	}
-	version := makeGoModVersion(fields[1])
-	return repo + versionSeparator + version.id, GoModDependency{Repo: repo, Version: version, Raw: raw}
+	dep.Version = version
+	return repo + versionSeparator + dep.versionId(), dep, nil
+}
+
+// makeGoModLocalDependency creates a dependency from a
+// replacement with a local folder.
+func makeGoModLocalDependency(mod module.Version, folder, raw string) (string, GoModDependency) {
+	return goModLocalFolder + "/" + goModLocalName(folder), GoModDependency{Module: mod.Path, LocalPath: folder, Raw: raw}
+}
+
+// goModLocalName answers the common code name for a local
+// replacement. The name of the folder isn't enough, since
+// ../a/util and ../b/util would share it, so it's followed by
+// a hash of the full path.
+func goModLocalName(folder string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(folder)))
+	return filepath.Base(folder) + "-" + hex.EncodeToString(sum[:4])
+}
+
+// goModReplacement answers the replacement for the module,
+// or nil. A replacement for the specific version wins.
+func goModReplacement(file *modfile.File, mod module.Version) *modfile.Replace {
+	var ans *modfile.Replace
+	for _, r := range file.Replace {
+		if r.Old.Path != mod.Path {
+			continue
+		}
+		if r.Old.Version == mod.Version {
+			return r
+		} else if r.Old.Version == "" {
+			ans = r
+		}
+	}
+	return ans
+}
+
+// goModExcluded answers true if the module version is excluded.
+func goModExcluded(file *modfile.File, mod module.Version) bool {
+	for _, e := range file.Exclude {
+		if e.Mod == mod {
+			return true
+		}
+	}
+	return false
+}
+
+// goModVersionInRange answers true if the version is in the
+// retracted interval.
+func goModVersionInRange(v string, r modfile.VersionInterval) bool {
+	return semver.Compare(v, r.Low) >= 0 && semver.Compare(v, r.High) <= 0
+}
+
+// goIgnoredDir answers true for folders that the go tool
+// ignores when looking for packages.
+func goIgnoredDir(name string) bool {
+	return name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
 }
 
 // GoModVersion represents a version from a go.sum file.
@@ -243,25 +782,18 @@ func makeGoModRepo(r string) string {
 // * extended version tag: "v0.0.0-only-publish-on-tag.0"
 // * version tag with incompatible repo structure: "v2.1.0+incompatible"
 // * commit sha: "v0.0.0-20200922220541-2c3bb06c6054"
-func makeGoModVersion(commit string) GoModVersion {
+func makeGoModVersion(commit string) (GoModVersion, error) {
 	if !strings.HasPrefix(commit, "v") {
-		panic("unknown commit: " + commit)
-	}
-	split := strings.Split(commit, "-")
-	switch len(split) {
-	case 1:
-		incompatible := `+incompatible`
-		if strings.HasSuffix(split[0], incompatible) {
-			return GoModVersion{GoModVersionTag, commit, strings.TrimSuffix(split[0], incompatible)}
+		return GoModVersion{}, fmt.Errorf("unknown version %q", commit)
+	}
+	if module.IsPseudoVersion(commit) {
+		rev, err := module.PseudoVersionRev(commit)
+		if err == nil {
+			return GoModVersion{GoModVersionCommit, commit, rev}, nil
 		}
-		return GoModVersion{GoModVersionTag, commit, split[0]}
-	case 3:
-		return GoModVersion{GoModVersionCommit, commit, split[2]}
-	default:
-		// Just let through the raw tag and see what happens!
-		// panic("unknown commit: " + commit)
-		return GoModVersion{GoModVersionTag, commit, commit}
 	}
+	incompatible := `+incompatible`
+	return GoModVersion{GoModVersionTag, commit, strings.TrimSuffix(commit, incompatible)}, nil
 }
 
 // gitCheckout answers the git checkout string for this version
@@ -286,6 +818,13 @@ const (
 	GoModVersionCommit                              // A SHA commit i.e. "v0.0.0-20200922220541-2c3bb06c6054"
 )
 
+// Ways of acquiring Go dependencies.
+const (
+	GoAcquireGit   = "git" // Clone the repo and checkout the version. The default.
+	GoAcquireProxy = "proxy"
+)
+
 const (
 	versionSeparator = `@`
+	goModLocalFolder = `local` // The common code folder for local replacements
 )
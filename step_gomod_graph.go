@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// goModGraph resolves the full module graph of a main module with
+// minimal version selection, by following the go.mod files of every
+// dependency. The go.mod files are read from common code if the
+// dependency has been archived, otherwise they are fetched from the
+// GOPROXY or a clone into a cache in the state folder.
+type goModGraph struct {
+	p       StepParams
+	step    GoModStep
+	main    *modfile.File
+	nodes   map[module.Version]*goModNode
+	mods    map[module.Version][]byte // The go.mod of every module loaded, after replacement
+	missing []module.Version          // Modules whose go.mod couldn't be loaded while planning
+}
+
+type goModNode struct {
+	Go   string // The go version, which determines graph pruning
+	Reqs []module.Version
+}
+
+func newGoModGraph(p StepParams, step GoModStep, main *modfile.File) *goModGraph {
+	return &goModGraph{p: p, step: step, main: main, nodes: make(map[module.Version]*goModNode), mods: make(map[module.Version][]byte)}
+}
+
+// buildList answers every module selected by MVS, excluding the
+// main module. If the main module is at go 1.17 or higher, modules
+// at 1.17 or higher have pruned graphs and only contribute their
+// immediate requirements, while older modules contribute their full
+// transitive closure. Otherwise the full graph is used.
+func (g *goModGraph) buildList() ([]module.Version, error) {
+	full := g.main.Go == nil || !goModPruned(g.main.Go.Version)
+	selected := make(map[string]string)
+	seen := make(map[module.Version]bool)
+	var visit func(mod module.Version, expand bool) error
+	visit = func(mod module.Version, expand bool) error {
+		if goModExcluded(g.main, mod) {
+			return nil
+		}
+		if v, ok := selected[mod.Path]; !ok || semver.Compare(mod.Version, v) > 0 {
+			selected[mod.Path] = mod.Version
+		}
+		if !expand || seen[mod] {
+			return nil
+		}
+		seen[mod] = true
+		node, err := g.load(mod)
+		if err != nil || node == nil {
+			return err
+		}
+		pruned := !full && goModPruned(node.Go)
+		for _, r := range node.Reqs {
+			if err := visit(r, !pruned); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, r := range g.main.Require {
+		if err := visit(r.Mod, true); err != nil {
+			return nil, err
+		}
+	}
+	var ans []module.Version
+	for path, v := range selected {
+		ans = append(ans, module.Version{Path: path, Version: v})
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].Path < ans[j].Path
+	})
+	return ans, nil
+}
+
+// load answers the requirements of the module, applying the
+// replacements of the main module. A nil node is answered if
+// the go.mod isn't available while planning.
+func (g *goModGraph) load(mod module.Version) (*goModNode, error) {
+	if node, ok := g.nodes[mod]; ok {
+		return node, nil
+	}
+	target := mod
+	if rep := goModReplacement(g.main, mod); rep != nil {
+		if modfile.IsDirectoryPath(rep.New.Path) {
+			// Local replacements have no version to select.
+			g.nodes[mod] = &goModNode{}
+			return g.nodes[mod], nil
+		}
+		target = rep.New
+	}
+	b, err := g.readMod(target)
+	if err != nil {
+		return nil, fmt.Errorf("go.mod for %v: %w", target, err)
+	}
+	if b == nil {
+		g.missing = append(g.missing, target)
+		return nil, nil
+	}
+	file, err := modfile.ParseLax(target.Path+"@"+target.Version+"/go.mod", b, nil)
+	if err != nil {
+		return nil, err
+	}
+	g.mods[target] = b
+	node := &goModNode{}
+	if file.Go != nil {
+		node.Go = file.Go.Version
+	}
+	for _, r := range file.Require {
+		node.Reqs = append(node.Reqs, r.Mod)
+	}
+	g.nodes[mod] = node
+	return node, nil
+}
+
+// mirrorMods adds the go.mod of every module in the graph to the
+// GOPROXY mirror, so the go tool can resolve the graph offline.
+func (g *goModGraph) mirrorMods() error {
+	for mod, b := range g.mods {
+		if err := goMirrorMod(g.p.Cfg.Output, mod, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMod answers the go.mod contents for the module. Modules
+// without a go.mod answer an empty file.
+func (g *goModGraph) readMod(mod module.Version) ([]byte, error) {
+	cache, err := goModCachePath(g.p.Cfg.Output, mod)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := os.ReadFile(cache); err == nil {
+		return b, nil
+	}
+	key, dep, err := makeGoModDependency(g.p, mod, mod.String())
+	if err != nil {
+		return nil, err
+	}
+	// An archived dependency has its go.mod in common code.
+	if archived, folder := g.step.archivedDependency(g.p, dep); folder != "" {
+		return g.readModFolder(folder, archived, cache)
+	}
+	if g.p.DryRun {
+		return nil, nil
+	}
+	if g.step.useProxy(g.p) {
+		b, err := newGoProxyClient(g.p.Cfg.GoProxy).Mod(mod)
+		if err == nil {
+			return b, g.writeCache(cache, b)
+		}
+		fmt.Println("goproxy failed, falling back to clone:", err)
+	}
+	// Otherwise fetch it into a temporary folder.
+	var b []byte
+	err = g.p.Shared.Do(cache, func() error {
+		if fsExists(cache) {
+			return nil
+		}
+		tmp := filepath.Join(stateFolderPath(g.p.Cfg.Output), "tmp", strings.ReplaceAll(key, "/", "_"))
+		os.RemoveAll(tmp)
+		defer os.RemoveAll(tmp)
+		b, err = g.showMod(dep, tmp)
+		if err != nil {
+			return err
+		}
+		return g.writeCache(cache, b)
+	})
+	if err != nil || b != nil {
+		return b, err
+	}
+	return os.ReadFile(cache)
+}
+
+// showMod fetches the dependency's repo into the temporary folder
+// and answers its go.mod at the dependency's version. The clone is
+// run directly instead of as a step, since there's nothing to
+// resume or archive. A version that can't be found is an error,
+// rather than whatever the default branch has.
+func (g *goModGraph) showMod(dep GoModDependency, tmp string) ([]byte, error) {
+	rev := dep.gitCheckout()
+	clone := CloneStep{Repo: dep.Repo, LocalFolder: tmp, Fetch: VcsFetch{Ref: rev}}
+	if err := clone.clone(g.p); err != nil {
+		return nil, err
+	}
+	// Prefer a major subdirectory, like the go tool.
+	if major := goModMajorDir(dep.Subdir(), dep.Module); major != "" {
+		b, err := g.p.vcs().Show(tmp, rev, path.Join(major, "go.mod"))
+		if err == nil && modfile.ModulePath(b) == dep.Module {
+			return b, nil
+		}
+	}
+	b, err := g.p.vcs().Show(tmp, rev, path.Join(dep.Subdir(), "go.mod"))
+	if errors.Is(err, errVcsNoFile) {
+		// Modules without a go.mod have no requirements.
+		return []byte("module " + modfile.AutoQuote(dep.Module) + "\n"), nil
+	}
+	return b, err
+}
+
+// readModFolder reads the go.mod for the dependency from the
+// folder and saves it to the cache, unless planning.
+func (g *goModGraph) readModFolder(folder string, dep GoModDependency, cache string) ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(folder, filepath.FromSlash(dep.ModuleDir(folder)), "go.mod"))
+	if os.IsNotExist(err) {
+		// Modules without a go.mod have no requirements.
+		b, err = []byte("module "+modfile.AutoQuote(dep.Module)+"\n"), nil
+	}
+	if err != nil || g.p.DryRun {
+		return b, err
+	}
+	return b, g.writeCache(cache, b)
+}
+
+func (g *goModGraph) writeCache(cache string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(cache), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(cache, b, 0644)
+}
+
+// goModCachePath answers the cache file for the go.mod of the module.
+func goModCachePath(outputFolder string, mod module.Version) (string, error) {
+	path, err := module.EscapePath(mod.Path)
+	if err != nil {
+		return "", err
+	}
+	version, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateFolderPath(outputFolder), "gomod", filepath.FromSlash(path), version+".mod"), nil
+}
+
+// goModPruned answers true if a module at the go version has
+// a pruned module graph.
+func goModPruned(goVersion string) bool {
+	return goVersion != "" && semver.Compare("v"+goVersion, "v1.17") >= 0
+}
+
+// ------------------------------------------------------------
+// GO.SUM
+
+// goSum is the contents of a go.sum file, keyed by "module@version"
+// for content hashes and "module@version/go.mod" for go.mod hashes.
+type goSum map[string]string
+
+// readGoSum reads the go.sum next to the go.mod. A missing go.sum
+// answers an empty sum.
+func readGoSum(path string) (goSum, error) {
+	sum := make(goSum)
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sum, nil
+	} else if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		sum[fields[0]+versionSeparator+fields[1]] = fields[2]
+	}
+	return sum, scanner.Err()
+}
+
+// crossCheck answers a description of every disagreement between
+// the build list and the modules with content in the go.sum.
+func (s goSum) crossCheck(list []module.Version) []string {
+	var ans []string
+	selected := make(map[string]string)
+	for _, m := range list {
+		selected[m.Path] = m.Version
+	}
+	for key := range s {
+		if strings.HasSuffix(key, "/go.mod") {
+			continue
+		}
+		path, version := splitModVersion(key)
+		if v, ok := selected[path]; !ok {
+			ans = append(ans, fmt.Sprintf("go.sum has %v, which was not selected", key))
+		} else if v != version && s[path+versionSeparator+v] == "" {
+			ans = append(ans, fmt.Sprintf("go.sum has %v, but %v was selected", key, v))
+		}
+	}
+	for _, m := range list {
+		if s[m.String()+"/go.mod"] == "" && s[m.String()] == "" {
+			ans = append(ans, fmt.Sprintf("%v was selected, but is not in go.sum", m))
+		}
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+// splitModVersion splits "module@version" into its parts.
+func splitModVersion(s string) (string, string) {
+	pos := strings.LastIndex(s, versionSeparator)
+	if pos < 0 {
+		return s, ""
+	}
+	return s[:pos], s[pos+1:]
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+)
+
+// GoProxyStep downloads a module from a GOPROXY and extracts it.
+// The .info, .mod and .zip files are kept in the state folder.
+type GoProxyStep struct {
+	Module module.Version
+	Folder string
+}
+
+func (s GoProxyStep) Run(p StepParams) error {
+	client := newGoProxyClient(p.Cfg.GoProxy)
+	fmt.Println("goproxy", s.Module, "from", client.Base)
+	dl, err := goProxyDownloadPath(p.Cfg.Output, s.Module)
+	if err != nil {
+		return err
+	}
+	version, err := module.EscapeVersion(s.Module.Version)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dl, os.ModePerm); err != nil {
+		return err
+	}
+	var info []byte
+	fetches := []struct {
+		ext string
+		fn  func(module.Version) ([]byte, error)
+	}{{".info", client.Info}, {".mod", client.Mod}, {".zip", client.Zip}}
+	for _, f := range fetches {
+		b, err := f.fn(s.Module)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(dl, version+f.ext), b, 0644); err != nil {
+			return err
+		}
+		if f.ext == ".info" {
+			info = b
+		}
+	}
+	// Unzip requires an empty folder, and a failed extraction
+	// shouldn't leave anything behind.
+	if err = os.RemoveAll(s.Folder); err != nil {
+		return err
+	}
+	err = modzip.Unzip(s.Folder, s.Module, filepath.Join(dl, version+".zip"))
+	if err != nil {
+		os.RemoveAll(s.Folder)
+		return err
+	}
+	meta := FolderMeta{Repo: s.Module.String(), Method: MetaGoProxy, Remote: client.Base + "/" + s.Module.Path, Ref: s.Module.Version}
+	// Proxies that know the origin report the commit, which
+	// isn't part of the zip.
+	var parsed struct {
+		Origin struct {
+			Hash string
+		}
+	}
+	if json.Unmarshal(info, &parsed) == nil {
+		meta.Head = parsed.Origin.Hash
+	}
+	return resetFolderMeta(p, s.Folder, meta)
+}
+
+func (s GoProxyStep) StepId() string {
+	return "goproxy:" + s.Folder
+}
+
+func (s GoProxyStep) StepInputs() interface{} {
+	return struct {
+		Module module.Version
+		Folder string
+	}{s.Module, s.Folder}
+}
+
+func (s GoProxyStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "%v to %v", s.Module, s.Folder)
+	n.Clones = []string{newGoProxyClient(p.Cfg.GoProxy).Base + "/" + s.Module.String()}
+	return n
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+)
+
+// writeTestProxy writes a filesystem GOPROXY with a single
+// module to dir.
+func writeTestProxy(t *testing.T, dir string, mod module.Version) {
+	t.Helper()
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "go.mod"), "module "+mod.Path+"\n")
+	writeTestFile(t, filepath.Join(src, "a.go"), "package a\n")
+	dl := filepath.Join(dir, filepath.FromSlash(mod.Path), "@v")
+	writeTestFile(t, filepath.Join(dl, mod.Version+".info"), `{"Version":"`+mod.Version+`"}`)
+	writeTestFile(t, filepath.Join(dl, mod.Version+".mod"), "module "+mod.Path+"\n")
+	f, err := os.Create(filepath.Join(dl, mod.Version+".zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = modzip.CreateFromDir(f, mod, src); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGoProxyStep(t *testing.T) {
+	mod := module.Version{Path: "example.com/mod", Version: "v1.0.0"}
+	proxy := t.TempDir()
+	writeTestProxy(t, proxy, mod)
+	server := httptest.NewServer(http.FileServer(http.Dir(proxy)))
+	defer server.Close()
+
+	cases := []struct {
+		name  string
+		proxy string
+	}{
+		{"file", "file://" + filepath.ToSlash(proxy)},
+		{"http", server.URL},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			output := t.TempDir()
+			folder := filepath.Join(output, "Common Code", mod.String())
+			p := StepParams{Cfg: Cfg{Output: output, GoProxy: c.proxy}}
+			if err := (GoProxyStep{Module: mod, Folder: folder}).Run(p); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"go.mod", "a.go"} {
+				if !fsExists(filepath.Join(folder, name)) {
+					t.Errorf("missing extracted %v", name)
+				}
+			}
+			dl, err := goProxyDownloadPath(output, mod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ext := range []string{".info", ".mod", ".zip"} {
+				if !fsExists(filepath.Join(dl, mod.Version+ext)) {
+					t.Errorf("missing download %v", ext)
+				}
+			}
+		})
+	}
+}
+
+func TestGoProxyStepMissing(t *testing.T) {
+	output := t.TempDir()
+	mod := module.Version{Path: "example.com/missing", Version: "v1.0.0"}
+	folder := filepath.Join(output, "Common Code", mod.String())
+	p := StepParams{Cfg: Cfg{Output: output, GoProxy: "file://" + filepath.ToSlash(t.TempDir())}}
+	if err := (GoProxyStep{Module: mod, Folder: folder}).Run(p); err == nil {
+		t.Fatal("expected an error for a module the proxy doesn't have")
+	}
+	if fsExists(folder) {
+		t.Error("failed download left the module folder")
+	}
+}
+
+func TestGoModProxyDependencyKeys(t *testing.T) {
+	// Two modules from the same repo at the same version are
+	// separate downloads.
+	repo := t.TempDir()
+	writeTestFile(t, filepath.Join(repo, "go.mod"), `module example.com/app
+
+go 1.18
+
+require (
+	github.com/org/tools/a v1.2.0
+	github.com/org/tools/b v1.2.0
+)
+`)
+	s := GoModStep{LocalFolder: repo, Repo: Repo{GoAcquire: GoAcquireProxy}}
+	deps, err := s.gatherDependencies(StepParams{}, []string{"go.mod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"github.com/org/tools/a@v1.2.0", "github.com/org/tools/b@v1.2.0"} {
+		if _, ok := deps[key]; !ok {
+			t.Errorf("missing dependency %v in %v", key, deps)
+		}
+	}
+}
+
+func TestGoModCloneDependencyKeys(t *testing.T) {
+	// Two modules cloned from the same repo at the same commit
+	// are separate dependencies that share a folder.
+	repo := t.TempDir()
+	writeTestFile(t, filepath.Join(repo, "go.mod"), `module example.com/app
+
+go 1.18
+
+require (
+	github.com/org/tools v0.0.0-20200101000000-abcdefabcdef
+	github.com/org/tools/v2 v2.0.0-20200101000000-abcdefabcdef
+)
+`)
+	s := GoModStep{LocalFolder: repo}
+	p := StepParams{CommonCodeFolder: t.TempDir()}
+	deps, err := s.gatherDependencies(p, []string{"go.mod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 2 {
+		t.Fatalf("want 2 dependencies, have %v", deps)
+	}
+	steps := s.makeDependencyStepList(p, deps)
+	if len(steps) != 1 {
+		t.Fatalf("want 1 step for the shared folder, have %v", len(steps))
+	}
+	if step := steps[0].(goModDependencyStep); len(step.Also) != 1 || step.Also[0].Module != "github.com/org/tools/v2" {
+		t.Errorf("want github.com/org/tools/v2 to share the step, have %v", step.Also)
+	}
+}
+
+// testStep is a step that calls a function.
+type testStep struct {
+	fn func() error
+}
+
+func (s testStep) Run(p StepParams) error {
+	return s.fn()
+}
+
+func (s testStep) Plan(p StepParams) PlanNode {
+	return newPlanNode(s, "")
+}
+
+func TestFallbackStep(t *testing.T) {
+	errStep := errors.New("step")
+	cases := []struct {
+		name     string
+		steps    error
+		then     error
+		fallback bool
+		want     error
+	}{
+		{"success", nil, nil, false, nil},
+		{"steps fail", errStep, nil, true, nil},
+		{"then fails", nil, errStep, false, errStep},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fellBack := false
+			s := FallbackStep{
+				Steps:    []Step{testStep{func() error { return c.steps }}},
+				Then:     []Step{testStep{func() error { return c.then }}},
+				Fallback: []Step{testStep{func() error { fellBack = true; return nil }}},
+			}
+			err := s.Run(StepParams{})
+			if !errors.Is(err, c.want) {
+				t.Errorf("got error %v, want %v", err, c.want)
+			}
+			if fellBack != c.fallback {
+				t.Errorf("fell back %v, want %v", fellBack, c.fallback)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+	modzip "golang.org/x/mod/zip"
+)
+
+// GoSumStep computes the go.sum hashes of an archived module, so
+// they can be compared to the go.sum files of the repos that require
+// it. Like mirroring, it needs to run before thinning. The hashes are
+// cached in the state folder, so they survive thinning.
+type GoSumStep struct {
+	Module module.Version
+	Folder string // The folder of the module's repo or download
+	Subdir string // The module's folder within the repo, see goModuleDir
+}
+
+func (s GoSumStep) Run(p StepParams) error {
+	cache, err := goSumCachePath(p.Cfg.Output, s.Module)
+	if err != nil {
+		return err
+	}
+	h := GoSumHash{}
+	if b, err := os.ReadFile(cache + ".h1"); err == nil {
+		h.Zip = string(b)
+		if b, err = os.ReadFile(cache + ".mod.h1"); err == nil {
+			h.Mod = string(b)
+		}
+		p.Output.SetGoSumHash(s.Module.String(), h)
+		return nil
+	}
+	zip, cleanup, err := goModuleZip(p, s.Module, s.Folder, goModuleDir(s.Folder, s.Subdir, s.Module.Path))
+	if err == errGoMirrorNoSource || err == errGoMirrorCheckout {
+		// Nothing is cached, so a later run can hash it.
+		p.AddError(fmt.Errorf("go.sum %v: %w", s.Module, err))
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer cleanup()
+	if h.Zip, err = dirhash.HashZip(zip, dirhash.Hash1); err != nil {
+		return err
+	}
+	mod, err := s.readMod(p)
+	if err != nil {
+		return err
+	}
+	if h.Mod, err = goSumModHash(mod); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(cache), os.ModePerm); err != nil {
+		return err
+	}
+	err = mergeErr(os.WriteFile(cache+".h1", []byte(h.Zip), 0644), os.WriteFile(cache+".mod.h1", []byte(h.Mod), 0644))
+	p.Output.SetGoSumHash(s.Module.String(), h)
+	return err
+}
+
+func (s GoSumStep) Plan(p StepParams) PlanNode {
+	return newPlanNode(s, "%v", s.Module)
+}
+
+// readMod answers the go.mod of the module, as the go tool sees it.
+func (s GoSumStep) readMod(p StepParams) ([]byte, error) {
+	version, err := module.EscapeVersion(s.Module.Version)
+	if err != nil {
+		return nil, err
+	}
+	if dl, err := goProxyDownloadPath(p.Cfg.Output, s.Module); err == nil {
+		if b, err := os.ReadFile(filepath.Join(dl, version+".mod")); err == nil {
+			return b, nil
+		}
+	}
+	sub := goModuleDir(s.Folder, s.Subdir, s.Module.Path)
+	b, err := os.ReadFile(filepath.Join(s.Folder, filepath.FromSlash(sub), "go.mod"))
+	if os.IsNotExist(err) {
+		return []byte("module " + s.Module.Path + "\n"), nil
+	}
+	return b, err
+}
+
+// goModuleZip answers the path to a module zip for the module, from
+// the mirror, the proxy download, or built from the git data. The
+// cleanup func removes any temporary file.
+func goModuleZip(p StepParams, mod module.Version, folder, subdir string) (string, func(), error) {
+	nop := func() {}
+	version, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return "", nop, err
+	}
+	for _, fn := range []func(string, module.Version) (string, error){goMirrorPath, goProxyDownloadPath} {
+		if dir, err := fn(p.Cfg.Output, mod); err == nil && fsExists(filepath.Join(dir, version+".zip")) {
+			return filepath.Join(dir, version+".zip"), nop, nil
+		}
+	}
+	if fsNotExists(filepath.Join(folder, ".git")) {
+		return "", nop, errGoMirrorNoSource
+	}
+	if err := goCheckoutFailed(p, folder); err != nil {
+		return "", nop, err
+	}
+	f, err := os.CreateTemp("", "guzzle-*.zip")
+	if err != nil {
+		return "", nop, err
+	}
+	cleanup := func() {
+		os.Remove(f.Name())
+	}
+	err = modzip.CreateFromVCS(f, mod, folder, "HEAD", subdir)
+	err = mergeErr(err, f.Close())
+	if err != nil {
+		cleanup()
+		return "", nop, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// goSumModHash answers the go.sum hash of a go.mod file.
+func goSumModHash(mod []byte) (string, error) {
+	return dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(mod)), nil
+	})
+}
+
+// goSumCachePath answers the cache path for the module's hashes,
+// without an extension.
+func goSumCachePath(outputFolder string, mod module.Version) (string, error) {
+	path, err := module.EscapePath(mod.Path)
+	if err != nil {
+		return "", err
+	}
+	version, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateFolderPath(outputFolder), "gosum", filepath.FromSlash(path), version), nil
+}
+
+// ------------------------------------------------------------
+// TYPES
+
+// GoSumHash are the go.sum hashes of a module.
+type GoSumHash struct {
+	Zip string `json:"zip"` // The hash of the module files
+	Mod string `json:"mod"` // The hash of the go.mod
+}
+
+// GoSumMismatch is a go.sum entry that disagrees with the archive.
+type GoSumMismatch struct {
+	Module   string `json:"module"`
+	Repo     string `json:"repo"` // The repo whose go.sum has the entry
+	File     string `json:"file"` // "zip" or "go.mod"
+	Expected string `json:"expected"`
+	Archived string `json:"archived"`
+}
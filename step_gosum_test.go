@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+func TestGoSumStepCheckoutFailed(t *testing.T) {
+	// A failed checkout leaves another version at HEAD, which
+	// must not be hashed or cached under this one.
+	out := t.TempDir()
+	folder := filepath.Join(out, "Common Code", "example.com", "a@v1.0.0")
+	writeTestFile(t, filepath.Join(folder, ".git", "HEAD"), "ref: refs/heads/main\n")
+	p := StepParams{Cfg: Cfg{Output: out}, Output: &StepOutput{}}
+	if err := resetFolderMeta(p, folder, FolderMeta{Checkout: MetaCheckoutFailed}); err != nil {
+		t.Fatal(err)
+	}
+	mod := module.Version{Path: "example.com/a", Version: "v1.0.0"}
+	if err := (GoSumStep{Module: mod, Folder: folder}).Run(p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Output.Errors) != 1 || !errors.Is(p.Output.Errors[0], errGoMirrorCheckout) {
+		t.Errorf("want a checkout error, have %v", p.Output.Errors)
+	}
+	cache, err := goSumCachePath(out, mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cache + ".h1"); !os.IsNotExist(err) {
+		t.Errorf("want no cached hash, have %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// HistoryStep preserves the git history of a clone next to it,
+// as a bundle or a bare mirror, before thinning removes it.
+type HistoryStep struct {
+	Folder string
+	Mode   string // One of the History consts
+}
+
+func (s HistoryStep) Run(p StepParams) error {
+	dst := s.path()
+	if fsNotExists(filepath.Join(s.Folder, ".git")) {
+		if fsExists(dst) {
+			// Preserved before the repo was thinned.
+			return nil
+		}
+		return fmt.Errorf("no git data in %v to preserve, remove it to clone again", s.Folder)
+	}
+	fmt.Println("preserve history", dst)
+	// Write beside the final path so an interrupted write
+	// never looks finished.
+	tmp := dst + ".tmp"
+	os.RemoveAll(tmp)
+	v := execVcs{}
+	var err error
+	switch s.Mode {
+	case HistoryBundle:
+		_, err = v.git(s.Folder, "bundle", "create", tmp, "--all")
+	case HistoryMirror:
+		err = v.Mirror(s.Folder, tmp)
+		if err == nil {
+			// Point the mirror at the real remote, not the thinned folder.
+			var remote string
+			remote, err = v.Origin(s.Folder)
+			if err == nil {
+				_, err = v.git(tmp, "remote", "set-url", "origin", remote)
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown history mode %v", s.Mode)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	// Replace the history of an earlier clone.
+	if err = os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (s HistoryStep) StepId() string {
+	return "history:" + s.Folder
+}
+
+func (s HistoryStep) StepInputs() interface{} {
+	return struct {
+		Folder, Mode string
+	}{s.Folder, s.Mode}
+}
+
+func (s HistoryStep) Plan(p StepParams) PlanNode {
+	return newPlanNode(s, "%v to %v", s.Mode, s.path())
+}
+
+// path answers the bundle file or mirror folder.
+func (s HistoryStep) path() string {
+	if s.Mode == HistoryMirror {
+		return s.Folder + ".git"
+	}
+	return s.Folder + ".bundle"
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+// Ways of preserving history.
+const (
+	HistoryBundle = "bundle" // A git bundle, restorable with git clone
+	HistoryMirror = "mirror" // A bare mirror
+)
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// SubmoduleStep acquires the submodules of a clone at the
+// commits it pins, and their submodules in turn. Each one is
+// redirected and cloned like any other repo. If any fail the
+// step fails once the rest are done, so the next run retries it.
+type SubmoduleStep struct {
+	Repo   string // The top-level repo, for the manifest
+	Folder string
+	Parent string // The folder's path in the top-level repo, empty at the top
+}
+
+func (s SubmoduleStep) Run(p StepParams) error {
+	if s.Parent == "" {
+		p.Output.AddSubmodule(s.Repo)
+	}
+	subs, err := p.vcs().Submodules(s.Folder)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, sub := range subs {
+		dst := filepath.Join(s.Folder, filepath.FromSlash(sub.Path))
+		// Git leaves an empty folder for each submodule, and
+		// the clone should own it.
+		if empty, err := fsDirEmpty(os.DirFS(dst), "."); err == nil && empty {
+			os.Remove(dst)
+		}
+		name := p.Cfg.GetRedirect(vcsRepoName(sub.Url))
+		full := path.Join(s.Parent, sub.Path)
+		fmt.Println("submodule", full, "in", s.Repo)
+		steps := []Step{
+			CloneStep{Repo: name, LocalFolder: dst},
+			CheckoutStep{LocalFolder: dst, Commit: sub.Commit},
+			SubmoduleStep{Repo: s.Repo, Folder: dst, Parent: full},
+		}
+		if err := runSteps(p, steps); err != nil {
+			// Keep going, so one missing submodule doesn't lose the rest.
+			p.AddError(fmt.Errorf("submodule %v in %v: %w", full, s.Repo, err))
+			failed++
+			continue
+		}
+		p.Output.AddSubmodule(s.Repo, RepoSubmodule{Path: full, Parent: s.Parent, Url: sub.Url, Repo: name, Commit: sub.Commit})
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v submodules failed in %v", failed, len(subs), s.Folder)
+	}
+	return nil
+}
+
+func (s SubmoduleStep) StepId() string {
+	return "submodules:" + s.Folder
+}
+
+func (s SubmoduleStep) StepInputs() interface{} {
+	return struct {
+		Repo, Folder, Parent string
+	}{s.Repo, s.Folder, s.Parent}
+}
+
+func (s SubmoduleStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "%v", s.Folder)
+	if fsNotExists(s.Folder) {
+		n.Desc += " (any in .gitmodules)"
+		return n
+	}
+	subs, err := p.vcs().Submodules(s.Folder)
+	if err != nil {
+		n.setErr(err)
+	}
+	for _, sub := range subs {
+		name := p.Cfg.GetRedirect(vcsRepoName(sub.Url))
+		c := newPlanNode(s, "%v at %v", path.Join(s.Parent, sub.Path), sub.Commit)
+		c.Clones = []string{p.Cfg.RemoteRepoSsh(name)}
+		if !isRemoteUrl(name) {
+			c.Clones = append(c.Clones, p.Cfg.RemoteRepoHttps(name))
+		}
+		n.Children = append(n.Children, c)
+	}
+	return n
+}
+
+// RepoSubmodule is a submodule archived inside a repo.
+type RepoSubmodule struct {
+	Path   string `json:"path"`             // Relative to the top-level repo
+	Parent string `json:"parent,omitempty"` // The path of the submodule that contains it, if it's nested
+	Url    string `json:"url"`
+	Repo   string `json:"repo"` // What was cloned, after redirects
+	Commit string `json:"commit"`
+}
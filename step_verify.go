@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// VerifyStep builds every Go repo in the config against the
+// archive, with the network disabled, to prove the archive is
+// self-sufficient. Modules come from the GOPROXY mirror in the
+// output, or common code if there's no mirror. Each repo is built
+// in a temporary copy so the archive isn't changed.
+type VerifyStep struct {
+	Vet bool // Also run go vet
+}
+
+func (s VerifyStep) Run(p StepParams) error {
+	tmp, err := os.MkdirTemp("", "guzzle-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	for _, repo := range p.Cfg.Repos {
+		if strings.ToLower(repo.Language) != "go" || strings.HasPrefix(repo.Name, "//") {
+			continue
+		}
+		results, err := s.verifyRepo(p, repo, tmp)
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			p.Output.AddVerify(r)
+			if !r.Passed {
+				p.AddError(fmt.Errorf("verify %v %v failed, missing %v", r.Repo, r.Module, r.Missing))
+			}
+		}
+	}
+	return writeVerifyReport(p.Cfg.Output, p.Output)
+}
+
+func (s VerifyStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "against %v", goMirrorRoot(p.Cfg.Output))
+	for _, repo := range p.Cfg.Repos {
+		if strings.ToLower(repo.Language) == "go" {
+			n.Children = append(n.Children, PlanNode{Step: "go build", Desc: repo.Name})
+		}
+	}
+	return n
+}
+
+// verifyRepo builds each module in the repo.
+func (s VerifyStep) verifyRepo(p StepParams, repo Repo, tmp string) ([]VerifyResult, error) {
+	local := p.Cfg.LocalRepo(repo.Name)
+	if fsNotExists(local) {
+		return []VerifyResult{{Repo: repo.Name, Output: "not archived"}}, nil
+	}
+	fmt.Println("verify", repo.Name)
+	work := filepath.Join(tmp, filepath.Base(local))
+	if err := os.RemoveAll(work); err != nil {
+		return nil, err
+	}
+	if err := fsCopyDir(local, tmp); err != nil {
+		return nil, err
+	}
+	mods, err := GoModStep{LocalFolder: work}.gatherMods()
+	if err != nil {
+		return nil, err
+	}
+	mirror := fsExists(goMirrorRoot(p.Cfg.Output))
+	var ans []VerifyResult
+	for _, mod := range mods {
+		dir := filepath.Join(work, filepath.Dir(mod))
+		if err = s.rewriteMod(p, repo, local, mod, dir, mirror); err != nil {
+			return nil, err
+		}
+		r := VerifyResult{Repo: repo.Name, Module: filepath.ToSlash(filepath.Dir(mod))}
+		args := [][]string{{"build", "./..."}}
+		if s.Vet {
+			args = append(args, []string{"vet", "./..."})
+		}
+		r.Passed = true
+		for _, a := range args {
+			out, err := s.goCmd(p, tmp, dir, a...)
+			r.Output += out
+			if err != nil {
+				r.Passed = false
+				r.Missing = verifyMissingModules(out)
+				break
+			}
+		}
+		ans = append(ans, r)
+	}
+	return ans, nil
+}
+
+// rewriteMod points the go.mod copied to dir at the archive. Local
+// replacements outside the repo were copied to common code under
+// new names, and without a mirror every dependency is replaced by
+// its folder in common code.
+func (s VerifyStep) rewriteMod(p StepParams, repo Repo, local, mod, dir string, mirror bool) error {
+	path := filepath.Join(dir, "go.mod")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	file, err := modfile.Parse(path, b, nil)
+	if err != nil {
+		return err
+	}
+	commonCode := commonCodePath(p.Cfg.Output)
+	// Local paths were named from the archived repo, not the copy.
+	from := filepath.Join(local, filepath.Dir(mod))
+	for _, rep := range append([]*modfile.Replace(nil), file.Replace...) {
+		if !modfile.IsDirectoryPath(rep.New.Path) {
+			continue
+		}
+		folder := filepath.Clean(filepath.Join(from, filepath.FromSlash(rep.New.Path)))
+		if rel, err := filepath.Rel(local, folder); err == nil && !strings.HasPrefix(rel, "..") {
+			continue
+		}
+		archived, err := filepath.Abs(filepath.Join(commonCode, goModLocalFolder, goModLocalName(folder)))
+		if err != nil {
+			return err
+		}
+		if err = file.AddReplace(rep.Old.Path, rep.Old.Version, archived, ""); err != nil {
+			return err
+		}
+	}
+	if !mirror {
+		if err = s.replaceDependencies(p, repo, local, mod, file); err != nil {
+			return err
+		}
+	}
+	file.Cleanup()
+	if b, err = file.Format(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// replaceDependencies replaces every dependency of the go.mod
+// with its folder in common code. Dependencies that weren't
+// archived are left for the build to report.
+func (s VerifyStep) replaceDependencies(p StepParams, repo Repo, local, mod string, file *modfile.File) error {
+	// Gather the way the run did, but only from what it cached.
+	gp := StepParams{Cfg: p.Cfg, CommonCodeFolder: commonCodePath(p.Cfg.Output), DryRun: true, GoImports: p.GoImports}
+	step := GoModStep{Repo: repo, OutputFolder: p.Cfg.Output, LocalFolder: local}
+	deps, err := step.gatherDependencies(gp, []string{mod})
+	if err != nil {
+		return err
+	}
+	for _, key := range sortedDependencyKeys(deps) {
+		dep := deps[key]
+		if dep.LocalPath != "" {
+			continue
+		}
+		dep, folder := step.archivedDependency(gp, dep)
+		if folder == "" {
+			continue
+		}
+		folder, err = filepath.Abs(filepath.Join(folder, filepath.FromSlash(dep.ModuleDir(folder))))
+		if err != nil {
+			return err
+		}
+		// Module replacements are replaced at their original path.
+		old := module.Version{Path: dep.Module}
+		for _, rep := range file.Replace {
+			if rep.New.Path == dep.Module && rep.New.Version == dep.Version.SumVersion {
+				old = rep.Old
+			}
+		}
+		if err = file.AddReplace(old.Path, old.Version, folder, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goCmd runs the go tool offline, with only the archive available.
+func (s VerifyStep) goCmd(p StepParams, tmp, dir string, args ...string) (string, error) {
+	proxy := "off"
+	if mirror := goMirrorRoot(p.Cfg.Output); fsExists(mirror) {
+		abs, err := filepath.Abs(mirror)
+		if err != nil {
+			return "", err
+		}
+		proxy = "file://" + filepath.ToSlash(abs)
+	}
+	p.Logln("go", args, "in", dir, "GOPROXY="+proxy)
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GOPROXY="+proxy,
+		"GOFLAGS=-mod=mod",
+		"GOSUMDB=off",
+		"GOVCS=*:off",
+		"GOWORK=off",
+		"GOTOOLCHAIN=local",
+		"GOMODCACHE="+filepath.Join(tmp, "modcache"),
+	)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// verifyMissingModules answers the modules the go tool failed
+// to find, which are reported as "module@version: reading ...".
+func verifyMissingModules(out string) []string {
+	found := make(map[string]bool)
+	for _, m := range verifyModuleRe.FindAllStringSubmatch(out, -1) {
+		found[m[1]] = true
+	}
+	var ans []string
+	for m := range found {
+		ans = append(ans, m)
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+// writeVerifyReport writes the verify results to the output.
+func writeVerifyReport(outputFolder string, output *StepOutput) error {
+	b, err := json.MarshalIndent(output.Verify, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputFolder, "verify.json"), b, 0644)
+}
+
+// ------------------------------------------------------------
+// TYPES
+
+// VerifyResult is the result of building a single Go module.
+type VerifyResult struct {
+	Repo    string   `json:"repo"`
+	Module  string   `json:"module"` // The folder of the module within the repo
+	Passed  bool     `json:"passed"`
+	Missing []string `json:"missing,omitempty"` // Modules the build couldn't find
+	Output  string   `json:"output,omitempty"`
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+var (
+	verifyModuleRe = regexp.MustCompile(`([a-zA-Z0-9][a-zA-Z0-9.\-_~/]*@v[0-9][a-zA-Z0-9.\-+]*): `)
+)
@@ -1,172 +1,334 @@
 package main
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
 )
 
 type Step interface {
 	Run(StepParams) error
+	// Plan describes what Run would do, without changing anything.
+	Plan(StepParams) PlanNode
 }
 
 type StepParams struct {
 	Cfg              Cfg
 	CommonCodeFolder string
 	Output           *StepOutput
+	Shared           *keyedOnce        // Guards folders shared between parallel pipelines
+	Journal          *Journal          // Records progress so interrupted runs can resume
+	DryRun           bool              // True while planning, when nothing can be fetched
+	GoImports        *goImportResolver // Resolves Go vanity paths, if enabled
+	Trash            *trash            // Quarantines deleted files, if enabled
+	Workers          *workerPool       // Shared by every parallel step, nil when the run is serial
+
+	onWorker bool // True on a step that's running on one of the Workers
 }
 
+// AddError records an error. It's safe to call from
+// parallel pipelines.
 func (p StepParams) AddError(err error) {
 	if p.Output != nil {
+		p.Output.mu.Lock()
+		defer p.Output.mu.Unlock()
 		p.Output.Errors = append(p.Output.Errors, err)
 	}
 }
 
-type StepOutput struct {
-	Errors []error
+// Logln prints only when the config is verbose.
+func (p StepParams) Logln(a ...interface{}) {
+	if p.Cfg.Verbose {
+		fmt.Println(a...)
+	}
 }
 
-// AuditStep performs an audit of file types in a folder.
-type AuditStep struct {
-	Folder string
+type StepOutput struct {
+	Errors     []error
+	GoModules  map[string][]string // The top-level repos that pulled in each module@version
+	Verify     []VerifyResult
+	GoSums     map[string]GoSumHash // The archived hashes of each module@version
+	GoSumErrs  []GoSumMismatch
+	Submodules map[string][]RepoSubmodule // The submodules archived in each top-level repo
+
+	mu sync.Mutex
 }
 
-func (s AuditStep) Run(p StepParams) error {
-	fmt.Println("audit", s.Folder)
-	types := make(map[string]AuditRow)
-	f := os.DirFS(s.Folder)
-	fs.WalkDir(f, ".", func(path string, d fs.DirEntry, err error) error {
-		if path == "." {
-			return nil
-		}
-		if !d.IsDir() {
-			var size int64 = 0
-			if fi, err := d.Info(); err == nil {
-				size = fi.Size()
-			}
-			ext := strings.ToLower(filepath.Ext(path))
-			if row, ok := types[ext]; ok {
-				row.Count += 1
-				row.Size += size
-				types[ext] = row
-			} else {
-				types[ext] = AuditRow{Name: ext, Size: size, Count: 1}
-			}
-		}
-		return nil
-	})
-	var rows []AuditRow
-	for _, r := range types {
-		rows = append(rows, r)
+// SetGoSumHash records the archived hashes of the module.
+func (o *StepOutput) SetGoSumHash(key string, h GoSumHash) {
+	if o == nil {
+		return
 	}
-	sort.Sort(SortAuditRowBySize(rows))
-	for i := len(rows) - 1; i >= 0; i-- {
-		fmt.Println(rows[i])
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.GoSums == nil {
+		o.GoSums = make(map[string]GoSumHash)
 	}
-	return nil
+	o.GoSums[key] = h
 }
 
-type AuditRow struct {
-	Name  string
-	Size  int64
-	Count int
+// GoSumHash answers the archived hashes of the module.
+func (o *StepOutput) GoSumHash(key string) (GoSumHash, bool) {
+	if o == nil {
+		return GoSumHash{}, false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	h, ok := o.GoSums[key]
+	return h, ok
 }
 
-type SortAuditRowBySize []AuditRow
+// AddGoSumMismatch records a go.sum entry that disagrees
+// with the archive.
+func (o *StepOutput) AddGoSumMismatch(m GoSumMismatch) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.GoSumErrs = append(o.GoSumErrs, m)
+}
 
-func (s SortAuditRowBySize) Len() int {
-	return len(s)
+// AddVerify records a verify result.
+func (o *StepOutput) AddVerify(r VerifyResult) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Verify = append(o.Verify, r)
 }
-func (s SortAuditRowBySize) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
+
+// AddSubmodule records submodules archived in the repo. With
+// none it records that the repo's submodules were acquired.
+func (o *StepOutput) AddSubmodule(repo string, subs ...RepoSubmodule) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.Submodules == nil {
+		o.Submodules = make(map[string][]RepoSubmodule)
+	}
+	o.Submodules[repo] = append(o.Submodules[repo], subs...)
 }
-func (s SortAuditRowBySize) Less(i, j int) bool {
-	if s[i].Size < s[j].Size {
-		return true
+
+// AddGoModule records that the repo pulled in the module.
+func (o *StepOutput) AddGoModule(key, repo string) {
+	if o == nil {
+		return
 	}
-	return s[i].Size < s[j].Size
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.GoModules == nil {
+		o.GoModules = make(map[string][]string)
+	}
+	for _, r := range o.GoModules[key] {
+		if r == repo {
+			return
+		}
+	}
+	o.GoModules[key] = append(o.GoModules[key], repo)
 }
 
 // CheckoutStep performs a git checkout.
 type CheckoutStep struct {
 	LocalFolder string
 	Commit      string
+	Sparse      []string // Only check out these folders, if any
 }
 
 func (s CheckoutStep) Run(p StepParams) error {
 	fmt.Println("git checkout", s.Commit)
-	fmt.Println("\t", s.LocalFolder)
-	cmd := exec.Command("git", "checkout", s.Commit)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	cmd.Dir = s.LocalFolder
-	out, err := cmd.Output()
-	if err != nil {
-		// I don't really know what to do with checkout errors
-		// Some repos do not seem to have tags that correspond
-		// to the tag in the go.mod.
-		p.AddError(fmt.Errorf("%w out: %v err: %v", err, string(out), string(stderr.Bytes())))
+	p.Logln("\t", s.LocalFolder)
+	err := p.vcs().Checkout(s.LocalFolder, s.Commit, s.Sparse)
+	missing := errors.Is(err, errVcsMissingRef)
+	if missing {
+		// Some repos don't have tags that correspond to the
+		// version in the go.mod. The folder is left at whatever
+		// was cloned, and the metadata says so.
+		p.AddError(err)
 	}
-	return nil
+	head := gitHead(p, s.LocalFolder)
+	merr := updateFolderMeta(p, s.LocalFolder, func(m *FolderMeta) {
+		m.Ref, m.Head, m.Checkout, m.CheckoutError = s.Commit, head, MetaCheckoutOk, ""
+		if err != nil {
+			m.Checkout, m.CheckoutError = MetaCheckoutFailed, err.Error()
+		}
+	})
+	if err != nil && !missing {
+		// Anything else leaves the folder in an unknown state, so
+		// the step fails and is redone on the next run.
+		return mergeErr(err, merr)
+	}
+	return mergeErr(merr, p.Journal.SetCommit(s.StepId(), head))
+}
+
+func (s CheckoutStep) StepId() string {
+	return "checkout:" + s.LocalFolder
+}
+
+func (s CheckoutStep) StepInputs() interface{} {
+	return struct {
+		LocalFolder, Commit string
+		Sparse              []string
+	}{s.LocalFolder, s.Commit, s.Sparse}
+}
+
+func (s CheckoutStep) Plan(p StepParams) PlanNode {
+	if len(s.Sparse) > 0 {
+		return newPlanNode(s, "%v in %v (sparse %v)", s.Commit, s.LocalFolder, strings.Join(s.Sparse, ", "))
+	}
+	return newPlanNode(s, "%v in %v", s.Commit, s.LocalFolder)
 }
 
 // CloneStep performs a git clone.
 type CloneStep struct {
 	Repo        string
 	LocalFolder string
+	Fetch       VcsFetch // If there's a ref, fetch only it, falling back to a full clone
 }
 
 func (s CloneStep) Run(p StepParams) error {
-	// This is more complicated than I'd like it because I don't
-	// know how I can access each repo.
-	err, redirect := s.tryClone(p, p.Cfg.RemoteRepoSsh(s.Repo))
+	err := s.clone(p)
+	if err != nil {
+		return err
+	}
+	head := gitHead(p, s.LocalFolder)
+	err = updateFolderMeta(p, s.LocalFolder, func(m *FolderMeta) {
+		m.Head = head
+		// Shallow fetches don't check anything out.
+		if head != "" && m.Method != MetaFetch {
+			m.Checkout = MetaCheckoutDefault
+		}
+	})
+	return mergeErr(err, p.Journal.SetCommit(s.StepId(), head))
+}
+
+// cloned records where the folder was cloned from.
+func (s CloneStep) cloned(p StepParams, method, remote string) error {
+	return resetFolderMeta(p, s.LocalFolder, FolderMeta{Repo: s.Repo, Method: method, Remote: remote})
+}
+
+func (s CloneStep) clone(p StepParams) error {
+	if cache := p.gitCache(); cache != nil {
+		err := s.tryRemotes(p, func(p StepParams, repo string) error {
+			return s.tryCache(p, *cache, repo)
+		})
+		if err == nil {
+			return nil
+		}
+		fmt.Println("git cache failed, falling back to clone:", err)
+	}
+	if s.Fetch.Ref != "" {
+		err := s.tryRemotes(p, s.tryFetch)
+		if err == nil {
+			return nil
+		}
+		fmt.Println("shallow fetch failed, falling back to clone:", err)
+	}
+	return s.tryRemotes(p, s.tryClone)
+}
+
+// tryRemotes tries fn with each way of accessing the repo.
+func (s CloneStep) tryRemotes(p StepParams, fn func(StepParams, string) error) error {
+	// I don't know how I can access each repo, so try
+	// ssh, then https, then wherever the remote says
+	// the repo moved to.
+	err := fn(p, p.Cfg.RemoteRepoSsh(s.Repo))
 	if err == nil {
 		return nil
 	}
-	err, redirect = s.tryClone(p, p.Cfg.RemoteRepoHttps(s.Repo))
+	if isRemoteUrl(s.Repo) {
+		// There's only one way to access a full remote.
+		return err
+	}
+	err = fn(p, p.Cfg.RemoteRepoHttps(s.Repo))
 	if err == nil {
 		return nil
 	}
-	err, _ = s.tryClone(p, redirect)
+	if redirect := vcsRedirect(err); redirect != "" {
+		return fn(p, redirect)
+	}
 	return err
 }
 
-func (s CloneStep) tryClone(p StepParams, repo string) (error, string) {
-	// This is more complicated than I'd like it because I don't
-	// know how I can access each repo.
-	redirect := repo
+func (s CloneStep) StepId() string {
+	return "clone:" + s.LocalFolder
+}
+
+func (s CloneStep) StepInputs() interface{} {
+	return struct {
+		Repo, LocalFolder string
+		Fetch             VcsFetch
+	}{s.Repo, s.LocalFolder, s.Fetch}
+}
+
+func (s CloneStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "%v to %v", s.Repo, s.LocalFolder)
+	if cache := p.gitCache(); cache != nil {
+		n.Desc += " (from " + cache.MirrorPath(p.Cfg.RemoteRepoHttps(s.Repo)) + ")"
+	} else if s.Fetch.Ref != "" {
+		n.Desc += " (shallow " + s.Fetch.Ref + ")"
+	}
+	n.Clones = []string{p.Cfg.RemoteRepoSsh(s.Repo)}
+	if !isRemoteUrl(s.Repo) {
+		n.Clones = append(n.Clones, p.Cfg.RemoteRepoHttps(s.Repo))
+	}
+	return n
+}
+
+func (s CloneStep) tryClone(p StepParams, repo string) error {
 	fmt.Println("git clone", repo, s.LocalFolder)
-	cmd := exec.Command("git", "clone", repo, s.LocalFolder)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	out, err := cmd.Output()
-	msg := string(stderr.Bytes())
-	if err != nil {
-		if strings.Contains(msg, `ssh: connect to host`) && strings.Contains(msg, `fatal: Could not read from remote repository.`) {
-			return errSshNotValid, redirect
-		}
-		r := s.getRedirect(msg)
-		if r != "" {
-			redirect = r
-		}
+	existed := fsExists(s.LocalFolder)
+	err := p.vcs().Clone(repo, s.LocalFolder)
+	if err != nil && !existed {
+		// Failed clones can leave a partial folder behind.
+		os.RemoveAll(s.LocalFolder)
 	}
-	return wrapErr(err, string(out)+" "+msg), redirect
+	if err == nil {
+		err = s.cloned(p, MetaClone, repo)
+	}
+	return err
 }
 
-func (s CloneStep) getRedirect(str string) string {
-	pre := `remote: Use 'git clone `
-	suf := `' instead`
-	if strings.Contains(str, pre) && strings.Contains(str, suf) {
-		pi := strings.Index(str, pre)
-		si := strings.Index(str, suf)
-		return str[pi+len(pre) : si]
+func (s CloneStep) tryCache(p StepParams, cache gitCache, repo string) error {
+	existed := fsExists(s.LocalFolder)
+	err := cache.Export(p, repo, s.LocalFolder)
+	if err != nil && !existed {
+		os.RemoveAll(s.LocalFolder)
 	}
-	return ""
+	if err == nil {
+		err = s.cloned(p, MetaCache, repo)
+	}
+	return err
+}
+
+func (s CloneStep) tryFetch(p StepParams, repo string) error {
+	fmt.Println("git fetch", repo, s.Fetch.Ref, s.LocalFolder)
+	existed := fsExists(s.LocalFolder)
+	err := p.vcs().Fetch(repo, s.LocalFolder, s.Fetch)
+	if err != nil && !existed {
+		os.RemoveAll(s.LocalFolder)
+	}
+	if err == nil {
+		err = s.cloned(p, MetaFetch, repo)
+	}
+	return err
+}
+
+// gitHead answers the commit checked out in the folder,
+// or an empty string if it can't be determined.
+func gitHead(p StepParams, folder string) string {
+	head, err := p.vcs().Head(folder)
+	if err != nil {
+		return ""
+	}
+	return head
 }
 
 // CopyStep performs a copy.
@@ -177,74 +339,168 @@ type CopyStep struct {
 
 func (s CopyStep) Run(p StepParams) error {
 	fmt.Println("copy", s.Src, "to", s.Dst)
-	return fsCopyDir(s.Src, s.Dst)
+	if err := fsCopyDir(s.Src, s.Dst); err != nil {
+		return err
+	}
+	dst := filepath.Join(s.Dst, filepath.Base(s.Src))
+	return resetFolderMeta(p, dst, FolderMeta{Method: MetaCopy, Remote: s.Src})
 }
 
-// DeleteGitStep deletes .git related data.
+func (s CopyStep) StepId() string {
+	return "copy:" + s.Src + ":" + s.Dst
+}
+
+func (s CopyStep) StepInputs() interface{} {
+	return struct {
+		Src, Dst string
+	}{s.Src, s.Dst}
+}
+
+func (s CopyStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "")
+	n.Copies = []PlanCopy{{s.Src, s.Dst}}
+	return n
+}
+
+// DeleteGitStep deletes .git related data, along with
+// anything else the rules delete.
 type DeleteGitStep struct {
 	Folder string
+	Rules  ThinRules
 }
 
 func (s DeleteGitStep) Run(p StepParams) error {
-	ext := gitDeletes
-	ext = append(ext, codeDeletes...)
-	step := DeleteStep{Folder: s.Folder, Ext: ext, Recurse: true}
-	return step.Run(p)
+	return s.deleteStep().Run(p)
+}
+
+func (s DeleteGitStep) StepId() string {
+	return "deletegit:" + s.Folder
 }
 
-// DeleteUnityStep deletes Unity-related data.
+func (s DeleteGitStep) StepInputs() interface{} {
+	return struct {
+		Folder string
+		Rules  ThinRules
+	}{s.Folder, s.Rules}
+}
+
+func (s DeleteGitStep) Plan(p StepParams) PlanNode {
+	n := s.deleteStep().Plan(p)
+	n.Step = newPlanNode(s, "").Step
+	return n
+}
+
+func (s DeleteGitStep) deleteStep() DeleteStep {
+	return DeleteStep{Folder: s.Folder, Rules: s.Rules.withSets(ThinSetLicense, ThinSetGoMod, ThinSetGit, ThinSetCode), Recurse: true}
+}
+
+// DeleteUnityStep deletes Unity-related data, along with
+// anything else the rules delete.
 type DeleteUnityStep struct {
 	Folder string
+	Rules  ThinRules
 }
 
 func (s DeleteUnityStep) Run(p StepParams) error {
-	ext := unityDeletes
-	ext = append(ext, mediaDeletes...)
+	return s.deleteStep().Run(p)
+}
+
+func (s DeleteUnityStep) StepId() string {
+	return "deleteunity:" + s.Folder
+}
+
+func (s DeleteUnityStep) StepInputs() interface{} {
+	return struct {
+		Folder string
+		Rules  ThinRules
+	}{s.Folder, s.Rules}
+}
+
+func (s DeleteUnityStep) Plan(p StepParams) PlanNode {
+	n := s.deleteStep().Plan(p)
+	n.Step = newPlanNode(s, "").Step
+	return n
+}
+
+func (s DeleteUnityStep) deleteStep() DeleteStep {
 	// Ton of stuff with no extension, as far as I can tell it's junk
-	ext = append(ext, "")
-	step := DeleteStep{Folder: s.Folder, Ext: ext, Recurse: true}
-	return step.Run(p)
+	rules := s.Rules.withSets(ThinSetLicense, ThinSetUnity, ThinSetMedia, ThinSetNoExt)
+	return DeleteStep{Folder: s.Folder, Rules: rules, Recurse: true}
 }
 
-// DeleteStep deletes all files and folders by extension.
+// DeleteStep deletes all files that match the thin rules.
 type DeleteStep struct {
 	Folder  string
-	Ext     []string
+	Rules   ThinRules
 	Recurse bool
 }
 
 func (s DeleteStep) Run(p StepParams) error {
 	var err error
+	werr := s.walk(p, func(abs, rule string, dir bool) {
+		p.Logln("delete ", abs, rule)
+		if dir {
+			// Git data is removed whole, it's never worth restoring.
+			err = mergeErr(err, os.RemoveAll(abs))
+			return
+		}
+		err = mergeErr(err, removeFile(p, abs, rule))
+	})
+	return mergeErr(werr, err)
+}
+
+func (s DeleteStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "%v", s.Folder)
+	if fsNotExists(s.Folder) {
+		n.Desc += " (folder does not exist yet)"
+		return n
+	}
+	if p.Cfg.Quarantine {
+		n.Desc += " (quarantined)"
+	}
+	err := s.walk(p, func(abs, rule string, dir bool) {
+		n.Deletes = append(n.Deletes, abs)
+	})
+	if err != nil {
+		n.setErr(err)
+	}
+	return n
+}
+
+// walk calls fn on every file that needs to be deleted, with
+// the rule that matched it. Folders are deleted a file at a time,
+// so keeps inside them are honoured, except for .git folders, which
+// are answered once, as a folder, if they match.
+func (s DeleteStep) walk(p StepParams, fn func(string, string, bool)) error {
+	m, err := s.Rules.compile(p.Cfg.ThinSets)
+	if err != nil {
+		return err
+	}
+	m.Root = s.Folder
 	f := os.DirFS(s.Folder)
 	fs.WalkDir(f, ".", func(path string, d fs.DirEntry, err error) error {
-		if path == "." {
+		if path == "." || err != nil {
 			return nil
 		}
 		if d.IsDir() && !s.Recurse {
 			return fs.SkipDir
 		}
-		if !d.IsDir() && s.needsDelete(path) {
-			abs := filepath.Join(s.Folder, path)
-			fmt.Println("delete ", abs)
-			if d.IsDir() {
-				err = mergeErr(err, os.RemoveAll(abs))
-			} else {
-				err = mergeErr(err, os.Remove(abs))
+		if d.IsDir() && strings.EqualFold(d.Name(), thinGitDir) {
+			// Only the git set deletes git data, and never a file at a time.
+			if rule, ok := m.Delete(path, true); ok {
+				fn(filepath.Join(s.Folder, path), rule, true)
 			}
+			return fs.SkipDir
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if rule, ok := m.Delete(path, false); ok {
+			fn(filepath.Join(s.Folder, path), rule, false)
 		}
 		return nil
 	})
-	return err
-}
-
-func (s DeleteStep) needsDelete(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	for _, cmp := range s.Ext {
-		if ext == cmp {
-			return true
-		}
-	}
-	return false
+	return nil
 }
 
 // DeleteEmptyFoldersStep deletes any folders with no contents.
@@ -262,6 +518,43 @@ func (s DeleteEmptyFoldersStep) Run(p StepParams) error {
 	return err
 }
 
+func (s DeleteEmptyFoldersStep) StepId() string {
+	return "deleteempty:" + s.Folder
+}
+
+func (s DeleteEmptyFoldersStep) StepInputs() interface{} {
+	return struct {
+		Folder     string
+		IncludeGit bool
+	}{s.Folder, s.IncludeGit}
+}
+
+// Plan answers the folders that are currently empty. Folders that
+// are emptied by earlier steps can't be known in advance.
+func (s DeleteEmptyFoldersStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "%v", s.Folder)
+	if fsNotExists(s.Folder) {
+		n.Desc += " (folder does not exist yet)"
+		return n
+	}
+	f := os.DirFS(s.Folder)
+	fs.WalkDir(f, ".", func(path string, d fs.DirEntry, err error) error {
+		if path == "." || err != nil || !d.IsDir() {
+			return nil
+		}
+		fullpath := filepath.Join(s.Folder, path)
+		if s.IncludeGit == true && filepath.Base(path) == ".git" {
+			n.Deletes = append(n.Deletes, fullpath)
+			return fs.SkipDir
+		}
+		if ok, err := fsDirEmpty(f, path); err == nil && ok {
+			n.Deletes = append(n.Deletes, fullpath)
+		}
+		return nil
+	})
+	return n
+}
+
 // deleteOne deletes any empty folders it finds, returning
 // true if it deleted something.
 func (s DeleteEmptyFoldersStep) deleteOne(p StepParams) (bool, error) {
@@ -310,12 +603,5 @@ func (s DeleteEmptyFoldersStep) isEmpty(f fs.FS, path string) bool {
 // CONST and VAR
 
 var (
-	gitDeletes   = []string{`.git`, `.github`, `.gitignore`, `.gitattributes`}
-	codeDeletes  = []string{`.sig`, `.dbg`, `.targets`, `.pri`, `.pack`, `.props`, `.user`, `.zip`, `.p7s`, `.pdb`, `.config`, `.sample`, `.bat`, `.idx`, `.json`, `.xcworkspacedata`, `.name`, `.pro`}
-	unityDeletes = []string{`.doc`, `.rendertexture`, `.pdb`, `.meta`, `.unity`, `.unitypackage`, `.prefab`, `.aar`, `.pak`, `.dat`, `.info`, `.strings`, `.mat`, `.cubemap`, `.anim`, `.guiskin`, `.shadervariants`, `.shadergraph`, `.7z`, `.asset`, `.bin`, `.physicmaterial`, `.rsp`, `.example`, `.modulemap`, `.pem`, `.colors`, `.touchosc`, `.bytes`, `.asmref`, `.gradle`, `.exp`, `.iuml`, `.savedsearch`, `.editorconfig`, `.appxmanifest`, `.blend`}
-	mediaDeletes = []string{`.ai`, `.dae`, `.exr`, `.fbx`, `.hdr`, `.jpg`, `.nib`, `.pdf`, `.png`, `.psd`, `.tga`, `.tif`, `.ttf`, `.gltf`, `.glb`, `.wav`, `.otf`, `.txt`, `.xml`, `.icns`, `.rtf`, `.puml`, `.csv`, `.md`, `.xaml`}
-
-	errSshNotValid = fmt.Errorf("SSH not valid")
-
 	errDeletedOne = fmt.Errorf("Deleted one")
 )
@@ -31,6 +31,39 @@ func (s VsPackagesStep) Run(p StepParams) error {
 	return s.acquireReferences(p, refs)
 }
 
+func (s VsPackagesStep) StepId() string {
+	return "vspackages:" + s.Folder
+}
+
+func (s VsPackagesStep) StepInputs() interface{} {
+	return struct {
+		Folder string
+	}{s.Folder}
+}
+
+// Plan answers the packages that would be copied. Packages can
+// only be known if the repo has already been cloned.
+func (s VsPackagesStep) Plan(p StepParams) PlanNode {
+	n := newPlanNode(s, "%v", s.Folder)
+	if fsNotExists(s.Folder) {
+		n.Desc += " (packages are found after clone)"
+		return n
+	}
+	projs, err := s.gatherProjs(p)
+	if err == nil {
+		var refs []VsPackageReference
+		refs, err = s.gatherReferences(projs)
+		for _, ref := range refs {
+			src, dst := s.packageSrcDst(p, ref)
+			if fsNotExists(filepath.Join(dst, ref.Version)) {
+				n.Copies = append(n.Copies, PlanCopy{src, dst})
+			}
+		}
+	}
+	n.setErr(err)
+	return n
+}
+
 // gatherProjs gathers all the .csproj files.
 func (s VsPackagesStep) gatherProjs(p StepParams) ([]string, error) {
 	var projs []string
@@ -79,30 +112,51 @@ func (s VsPackagesStep) gatherReferences(projs []string) ([]VsPackageReference,
 
 // acquireReferences copies all references to the common code folder.
 func (s VsPackagesStep) acquireReferences(p StepParams, refs []VsPackageReference) error {
-	home, err := os.UserHomeDir()
-	checkErr(err)
-	packages := filepath.Join(home, `.nuget`, `packages`)
-	// For now we rely on packages being in a common location.
-	// This will definitely change as we're working on this.
 	for _, ref := range refs {
-		include := strings.ToLower(ref.Include)
-		src := filepath.Join(packages, include, ref.Version)
+		src, dst := s.packageSrcDst(p, ref)
 		if fsNotExists(src) {
 			return fmt.Errorf("vspackages file does not exist: " + src)
 		}
-		dst := filepath.Join(p.CommonCodeFolder, `nuget`, include)
 		checkdst := filepath.Join(dst, ref.Version)
-		if fsExists(checkdst) {
-			continue
-		}
-		fmt.Println("copy", src, "to", dst)
-		if err = fsCopyDir(src, dst); err != nil {
+		name := strings.ToLower(ref.Include) + "@" + ref.Version
+		// Another repo might be copying the same package in parallel.
+		err := p.Shared.Do(checkdst, func() error {
+			if fsNotExists(checkdst) {
+				fmt.Println("copy", src, "to", dst)
+				if err := fsCopyDir(src, dst); err != nil {
+					return err
+				}
+				meta := FolderMeta{Repo: name, Method: MetaCopy, Remote: src, Ref: ref.Version}
+				if err := resetFolderMeta(p, checkdst, meta); err != nil {
+					return err
+				}
+			}
+			if !p.Cfg.Licenses {
+				return nil
+			}
+			return runStep(p, LicenseStep{Name: name, Kind: LicenseNuGet, Folder: checkdst})
+		})
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// packageSrcDst answers the source and destination folders for
+// the package.
+func (s VsPackagesStep) packageSrcDst(p StepParams, ref VsPackageReference) (string, string) {
+	home, err := os.UserHomeDir()
+	checkErr(err)
+	// For now we rely on packages being in a common location.
+	// This will definitely change as we're working on this.
+	packages := filepath.Join(home, `.nuget`, `packages`)
+	include := strings.ToLower(ref.Include)
+	src := filepath.Join(packages, include, ref.Version)
+	dst := filepath.Join(p.CommonCodeFolder, `nuget`, include)
+	return src, dst
+}
+
 // isTest is a dumb, stupid hardcoded filter for test projects.
 func (s VsPackagesStep) isTest(p string) bool {
 	p = strings.ToLower(p)
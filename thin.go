@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ThinRules decide which files thinning deletes. Patterns are
+// gitignore-style: a pattern without a slash matches a name at
+// any depth, one with a slash is relative to the repo, a trailing
+// slash only matches folders, ** matches any number of folders
+// and ! re-includes what an earlier pattern in the same list
+// matched. Everything in a matched folder matches. Case is ignored.
+// A pattern of class:<name> matches files by their content, i.e.
+// class:image or class:build-script, beside:<file>:<pattern>
+// only matches files in a folder that also holds the file, i.e.
+// beside:go.mod:*.json, and file:<pattern> never matches a folder,
+// i.e. file:*.config leaves a .config folder alone.
+type ThinRules struct {
+	Sets   []string `json:"sets,omitempty"`   // Named rule sets to apply, built in or from thin_sets
+	Delete []string `json:"delete,omitempty"` // Patterns of files to delete
+	Keep   []string `json:"keep,omitempty"`   // Patterns of files to keep, which win over any delete
+}
+
+// withSets answers the rules with the sets applied first.
+func (r ThinRules) withSets(sets ...string) ThinRules {
+	r.Sets = append(append([]string{}, sets...), r.Sets...)
+	return r
+}
+
+// merge answers the rules with b applied after r, so patterns
+// in b can re-include what r deletes.
+func (r ThinRules) merge(b ThinRules) ThinRules {
+	r.Sets = append(append([]string{}, r.Sets...), b.Sets...)
+	r.Delete = append(append([]string{}, r.Delete...), b.Delete...)
+	r.Keep = append(append([]string{}, r.Keep...), b.Keep...)
+	return r
+}
+
+// compile answers a matcher for the rules. Sets are looked up
+// in named first, so configs can replace the built-in ones.
+func (r ThinRules) compile(named map[string]ThinRules) (thinMatcher, error) {
+	var m thinMatcher
+	err := m.add(r, named, nil)
+	return m, err
+}
+
+// thinMatcher matches files against compiled rules. Each list
+// is matched on its own, so a ! in one set can't undo another.
+type thinMatcher struct {
+	Root    string // The folder being thinned, to classify files in
+	deletes []thinList
+	keeps   []thinList
+}
+
+func (m *thinMatcher) add(r ThinRules, named map[string]ThinRules, seen []string) error {
+	name := "thin"
+	if len(seen) > 0 {
+		name = seen[len(seen)-1]
+	}
+	for _, set := range r.Sets {
+		for _, s := range seen {
+			if s == set {
+				return fmt.Errorf("thin set %v includes itself", set)
+			}
+		}
+		rules, ok := named[set]
+		if !ok {
+			rules, ok = thinSets[set]
+		}
+		if !ok {
+			return fmt.Errorf("unknown thin set %v", set)
+		}
+		if err := m.add(rules, named, append(seen, set)); err != nil {
+			return err
+		}
+	}
+	deletes, err := compileThinList(name, r.Delete)
+	if err != nil {
+		return err
+	}
+	keeps, err := compileThinList(name, r.Keep)
+	if err != nil {
+		return err
+	}
+	if len(deletes.Patterns) > 0 {
+		m.deletes = append(m.deletes, deletes)
+	}
+	if len(keeps.Patterns) > 0 {
+		m.keeps = append(m.keeps, keeps)
+	}
+	return nil
+}
+
+// Delete answers true if the file or folder, relative to the folder
+// being thinned and with forward slashes, should be deleted, along
+// with the rule that matched it, i.e. "media:file:*.png".
+func (m thinMatcher) Delete(path string, dir bool) (string, bool) {
+	// Only classify the file if a class pattern needs it.
+	var classes *FileClasses
+	classify := func() FileClasses {
+		if classes == nil {
+			c, _ := classifyFile(filepath.Join(m.Root, filepath.FromSlash(path)))
+			classes = &c
+		}
+		return *classes
+	}
+	beside := func(name string) bool {
+		return fsExists(filepath.Join(m.Root, filepath.Dir(filepath.FromSlash(path)), name))
+	}
+	for _, l := range m.keeps {
+		if _, ok := l.match(path, dir, classify, beside); ok {
+			return "", false
+		}
+	}
+	for _, l := range m.deletes {
+		if pat, ok := l.match(path, dir, classify, beside); ok {
+			return l.Name + ":" + pat.Source, true
+		}
+	}
+	return "", false
+}
+
+// thinList is an ordered list of patterns, where the last match wins.
+type thinList struct {
+	Name     string // The set the patterns came from
+	Patterns []thinPattern
+}
+
+func compileThinList(name string, patterns []string) (thinList, error) {
+	l := thinList{Name: name}
+	for _, s := range patterns {
+		pat, ok, err := compileThinPattern(s)
+		if err != nil {
+			return l, err
+		} else if ok {
+			l.Patterns = append(l.Patterns, pat)
+		}
+	}
+	return l, nil
+}
+
+// match answers true if the path or any folder containing it
+// matches, which is how git decides whether a path is ignored,
+// along with the pattern that matched.
+func (l thinList) match(path string, dir bool, classify func() FileClasses, beside func(string) bool) (thinPattern, bool) {
+	parts := strings.Split(path, "/")
+	for i := range parts {
+		if pat, ok := l.matchOne(strings.Join(parts[:i+1], "/"), dir || i < len(parts)-1, classify, beside); ok {
+			return pat, true
+		}
+	}
+	return thinPattern{}, false
+}
+
+func (l thinList) matchOne(path string, dir bool, classify func() FileClasses, beside func(string) bool) (thinPattern, bool) {
+	var last thinPattern
+	matched := false
+	for _, pat := range l.Patterns {
+		if matched == !pat.Negate {
+			// It can't change the outcome, and classifying reads the file.
+			continue
+		}
+		if (pat.FileOnly || pat.Beside != "") && dir {
+			continue
+		} else if pat.Beside != "" && !beside(pat.Beside) {
+			continue
+		}
+		switch {
+		case pat.Class != 0:
+			if !dir && classify().Has(pat.Class) {
+				last, matched = pat, !pat.Negate
+			}
+		case pat.DirOnly && !dir:
+		case pat.re.MatchString(path):
+			last, matched = pat, !pat.Negate
+		}
+	}
+	return last, matched
+}
+
+// thinPattern is a single compiled gitignore-style pattern,
+// or a class of files.
+type thinPattern struct {
+	Source   string // The pattern as written
+	Negate   bool
+	DirOnly  bool
+	FileOnly bool
+	Class    FileClasses
+	Beside   string // A file that must be in the same folder, if any
+	re       *regexp.Regexp
+}
+
+// compileThinPattern compiles s, answering false for blank
+// lines and comments.
+func compileThinPattern(s string) (thinPattern, bool, error) {
+	if pat, ok := thinPatterns.Load(s); ok {
+		return pat.(thinPattern), true, nil
+	}
+	pat := thinPattern{Source: strings.TrimSpace(s)}
+	glob := pat.Source
+	if glob == "" || strings.HasPrefix(glob, "#") {
+		return pat, false, nil
+	}
+	if strings.HasPrefix(glob, "!") {
+		pat.Negate, glob = true, glob[1:]
+	}
+	if strings.HasPrefix(glob, thinBesidePrefix) {
+		beside, rest, ok := strings.Cut(strings.TrimPrefix(glob, thinBesidePrefix), ":")
+		if !ok || beside == "" || strings.Contains(beside, "/") || rest == "" {
+			return pat, false, fmt.Errorf("thin pattern %q: beside needs a file name and a pattern", s)
+		}
+		pat.Beside, glob = beside, rest
+	}
+	if strings.HasPrefix(glob, thinFilePrefix) {
+		pat.FileOnly, glob = true, strings.TrimPrefix(glob, thinFilePrefix)
+	}
+	if strings.HasPrefix(glob, thinClassPrefix) {
+		class, ok := parseFileClass(strings.TrimPrefix(glob, thinClassPrefix))
+		if !ok {
+			return pat, false, fmt.Errorf("thin pattern %q: unknown class", s)
+		}
+		pat.Class = class
+		thinPatterns.Store(s, pat)
+		return pat, true, nil
+	}
+	if strings.HasSuffix(glob, "/") {
+		if pat.FileOnly {
+			return pat, false, fmt.Errorf("thin pattern %q only matches files, so it can't end with a slash", s)
+		}
+		pat.DirOnly, glob = true, strings.TrimRight(glob, "/")
+	}
+	expr := "(?i)^"
+	if strings.Contains(glob, "/") {
+		glob = strings.TrimPrefix(glob, "/")
+	} else {
+		expr += "(?:.*/)?"
+	}
+	if glob == "" {
+		return pat, false, fmt.Errorf("thin pattern %q matches nothing", s)
+	}
+	expr += thinGlobExpr(glob) + "$"
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return pat, false, fmt.Errorf("thin pattern %q: %w", s, err)
+	}
+	pat.re = re
+	thinPatterns.Store(s, pat)
+	return pat, true, nil
+}
+
+// thinGlobExpr translates a gitignore glob to a regular expression.
+func thinGlobExpr(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return sb.String()
+}
+
+// thinExts answers patterns that match files by extension. They
+// never match folders, so a .github or x.config folder is kept.
+func thinExts(exts ...string) []string {
+	var patterns []string
+	for _, ext := range exts {
+		patterns = append(patterns, thinFilePrefix+"*"+ext)
+	}
+	return patterns
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+const (
+	thinClassPrefix  = "class:"
+	thinBesidePrefix = "beside:"
+	thinFilePrefix   = "file:"
+	thinGitDir       = ".git"
+)
+
+// The built-in thin sets.
+const (
+	ThinSetGit      = "git"      // Git metadata, deleted from every repo
+	ThinSetCode     = "code"     // Build and tool output, deleted from every repo
+	ThinSetUnity    = "unity"    // Unity assets, deleted from repos without a language
+	ThinSetMedia    = "media"    // Images, fonts, documents and the like, deleted from repos without a language
+	ThinSetNoExt    = "noext"    // Files without an extension that aren't source, licenses or build scripts, deleted from repos without a language
+	ThinSetLicense  = "license"  // Keeps licenses and notices, applied to every repo
+	ThinSetGoMod    = "gomod"    // Keeps JSON files beside a go.mod, which the code deletes, applied to every repo
+	ThinSetTestdata = "testdata" // Keeps test fixtures
+)
+
+var (
+	thinSets = map[string]ThinRules{
+		ThinSetGit:      {Delete: append([]string{thinGitDir + "/"}, thinExts(`.git`, `.github`, `.gitignore`, `.gitattributes`)...)},
+		ThinSetCode:     {Delete: thinExts(`.sig`, `.dbg`, `.targets`, `.pri`, `.pack`, `.props`, `.user`, `.zip`, `.p7s`, `.pdb`, `.config`, `.sample`, `.bat`, `.idx`, `.json`, `.xcworkspacedata`, `.name`, `.pro`)},
+		ThinSetUnity:    {Delete: thinExts(`.doc`, `.rendertexture`, `.pdb`, `.meta`, `.unity`, `.unitypackage`, `.prefab`, `.aar`, `.pak`, `.dat`, `.info`, `.strings`, `.mat`, `.cubemap`, `.anim`, `.guiskin`, `.shadervariants`, `.shadergraph`, `.7z`, `.asset`, `.bin`, `.physicmaterial`, `.rsp`, `.example`, `.modulemap`, `.pem`, `.colors`, `.touchosc`, `.bytes`, `.asmref`, `.gradle`, `.exp`, `.iuml`, `.savedsearch`, `.editorconfig`, `.appxmanifest`, `.blend`)},
+		ThinSetMedia:    {Delete: thinExts(`.ai`, `.dae`, `.exr`, `.fbx`, `.hdr`, `.jpg`, `.nib`, `.pdf`, `.png`, `.psd`, `.tga`, `.tif`, `.ttf`, `.gltf`, `.glb`, `.wav`, `.otf`, `.txt`, `.xml`, `.icns`, `.rtf`, `.puml`, `.csv`, `.md`, `.xaml`)},
+		ThinSetNoExt:    {Delete: []string{`*`, `!*.*`, `!*/`, `!class:source`, `!class:license`, `!class:build-script`}},
+		ThinSetLicense:  {Keep: []string{`LICENSE*`, `LICENCE*`, `COPYING*`, `NOTICE*`, `PATENTS*`}},
+		ThinSetGoMod:    {Keep: []string{`beside:go.mod:*.json`}},
+		ThinSetTestdata: {Keep: []string{`testdata/`}},
+	}
+
+	// thinPatterns caches the compiled patterns.
+	thinPatterns sync.Map
+)
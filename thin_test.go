@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestThinDefaultSetsKeepFolders(t *testing.T) {
+	// Extension patterns only match files, so folders named
+	// like one survive, along with what's in them.
+	out := t.TempDir()
+	folder := filepath.Join(out, "repo")
+	files := map[string]bool{
+		".github/workflows/ci.yml": true,
+		"x.config/settings.go":     true,
+		"x.bin/data.go":            true,
+		"main.go":                  true,
+		"LICENSE.md":               true,
+		"go.mod":                   true,
+		"testdata.json":            true,
+		"sub/cfg.json":             false,
+		"app.config":               false,
+		"logo.png":                 false,
+		".gitignore":               false,
+	}
+	for path := range files {
+		writeTestFile(t, filepath.Join(folder, filepath.FromSlash(path)), "x\n")
+	}
+	writeTestFile(t, filepath.Join(folder, ".git", "objects", "ab", "cdef"), "x\n")
+	trash, err := newTrash(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := StepParams{Cfg: Cfg{Output: out, Quarantine: true}, Output: &StepOutput{}, Trash: trash}
+	for _, step := range []Step{DeleteUnityStep{Folder: folder}, DeleteGitStep{Folder: folder}} {
+		if err := step.Run(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, kept := range files {
+		if fsExists(filepath.Join(folder, filepath.FromSlash(path))) != kept {
+			t.Errorf("%v: want kept %v", path, kept)
+		}
+	}
+	if fsExists(filepath.Join(folder, ".git")) {
+		t.Error("want .git deleted")
+	}
+	entries, err := readTrash(p.Trash.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if filepath.Base(filepath.Dir(e.Path)) == "ab" {
+			t.Errorf("want git data removed whole, have %v quarantined", e.Path)
+		}
+	}
+}
+
+func TestThinGitPlan(t *testing.T) {
+	// The .git folder is a single delete in the plan.
+	folder := t.TempDir()
+	for _, path := range []string{".git/HEAD", ".git/objects/ab/cdef", ".git/objects/cd/ef01", "main.go"} {
+		writeTestFile(t, filepath.Join(folder, filepath.FromSlash(path)), "x\n")
+	}
+	n := DeleteGitStep{Folder: folder}.Plan(StepParams{})
+	if len(n.Deletes) != 1 || n.Deletes[0] != filepath.Join(folder, ".git") {
+		t.Errorf("want only .git deleted, have %v", n.Deletes)
+	}
+}
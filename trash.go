@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrashEntry is a file moved to the trash instead of being deleted.
+type TrashEntry struct {
+	Path   string    `json:"path"` // Relative to the output, with forward slashes
+	Size   int64     `json:"size"`
+	Sha256 string    `json:"sha256,omitempty"` // Empty for links
+	Rule   string    `json:"rule"`             // What deleted it, i.e. "media:file:*.png"
+	Time   time.Time `json:"time"`
+}
+
+// trash quarantines the files a run deletes, so they can be
+// restored. Each run has its own folder in the state folder,
+// with the files at their paths in the output and a manifest
+// of what was moved and why.
+type trash struct {
+	Output string
+	Dir    string
+	mu     sync.Mutex
+}
+
+// newTrash answers a trash for a new run in the output. The run's
+// folder is created here, so runs that start in the same second
+// can't share it.
+func newTrash(output string) (*trash, error) {
+	if err := os.MkdirAll(trashPath(output), os.ModePerm); err != nil {
+		return nil, err
+	}
+	run := time.Now().UTC().Format(trashRunFormat)
+	dir := filepath.Join(trashPath(output), run)
+	// Runs that start in the same second, padded so they sort in order.
+	for i := 2; ; i++ {
+		err := os.Mkdir(dir, os.ModePerm)
+		if err == nil {
+			return &trash{Output: output, Dir: dir}, nil
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+		dir = filepath.Join(trashPath(output), fmt.Sprintf("%v-%03d", run, i))
+	}
+}
+
+// Close removes the run's folder if nothing was quarantined.
+func (t *trash) Close() {
+	// Only succeeds if it's empty.
+	os.Remove(t.Dir)
+}
+
+// Remove moves the file to the trash, recording the rule that
+// removed it.
+func (t *trash) Remove(path, rule string) error {
+	rel, err := filepath.Rel(t.Output, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("can't quarantine %v, it's outside the output", path)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	// Links are moved as they are.
+	var size int64
+	var sum string
+	if info.Mode().IsRegular() {
+		if size, sum, err = hashFile(path); err != nil {
+			return err
+		}
+	}
+	dst := filepath.Join(t.Dir, trashFiles, rel)
+	if err = os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	if err = os.Rename(path, dst); err != nil {
+		return err
+	}
+	e := TrashEntry{Path: filepath.ToSlash(rel), Size: size, Sha256: sum, Rule: rule, Time: time.Now().UTC()}
+	return t.record(e)
+}
+
+// RemoveAll moves every file in the folder to the trash, and
+// then removes the folder. Git data isn't worth restoring, so
+// it's removed whole.
+func (t *trash) RemoveAll(folder, rule string) error {
+	err := filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && strings.EqualFold(d.Name(), thinGitDir) {
+			return mergeErr(os.RemoveAll(path), fs.SkipDir)
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return t.Remove(path, rule)
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(folder)
+}
+
+// record appends the entry to the manifest. It's written as it
+// goes, so an interrupted run can still be restored.
+func (t *trash) record(e TrashEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, err := os.OpenFile(filepath.Join(t.Dir, trashManifest), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	return mergeErr(err, f.Close())
+}
+
+// removeFile deletes the file, or quarantines it if the run has a trash.
+func removeFile(p StepParams, path, rule string) error {
+	if p.Trash != nil {
+		return p.Trash.Remove(path, rule)
+	}
+	return os.Remove(path)
+}
+
+// removeAll deletes the folder, or quarantines it if the run has a trash.
+func removeAll(p StepParams, folder, rule string) error {
+	if p.Trash != nil {
+		return p.Trash.RemoveAll(folder, rule)
+	}
+	return os.RemoveAll(folder)
+}
+
+// readTrash answers the files quarantined in a run.
+func readTrash(dir string) ([]TrashEntry, error) {
+	f, err := os.Open(filepath.Join(dir, trashManifest))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []TrashEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e TrashEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%v: %w", f.Name(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// restoreTrash moves the files quarantined in a run back to the
+// output. Files that are in the way are left alone and reported.
+// The run is removed once everything is restored.
+func restoreTrash(output, run string) (int, []error, error) {
+	dir := filepath.Join(trashPath(output), run)
+	entries, err := readTrash(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+	restored := 0
+	var errs []error
+	for _, e := range entries {
+		src := filepath.Join(dir, trashFiles, filepath.FromSlash(e.Path))
+		dst := filepath.Join(output, filepath.FromSlash(e.Path))
+		if fsNotExists(src) {
+			// Restored earlier.
+			continue
+		}
+		if fsExists(dst) {
+			errs = append(errs, fmt.Errorf("not restoring %v, it already exists", e.Path))
+			continue
+		}
+		err := os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+		if err == nil {
+			err = os.Rename(src, dst)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		restored++
+	}
+	if len(errs) < 1 {
+		err = os.RemoveAll(dir)
+	}
+	return restored, errs, err
+}
+
+// trashRuns answers the runs with quarantined files, oldest first.
+func trashRuns(output string) ([]string, error) {
+	dirs, err := os.ReadDir(trashPath(output))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var runs []string
+	for _, d := range dirs {
+		if d.IsDir() {
+			runs = append(runs, d.Name())
+		}
+	}
+	sort.Strings(runs)
+	return runs, nil
+}
+
+func trashPath(output string) string {
+	return filepath.Join(stateFolderPath(output), "trash")
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+const (
+	trashRunFormat = "20060102-150405"
+	trashFiles     = "files"
+	trashManifest  = "deleted.jsonl"
+)
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewTrashSameSecond(t *testing.T) {
+	// Runs that haven't deleted anything yet still get their own folder.
+	out := t.TempDir()
+	var dirs []string
+	for i := 0; i < 3; i++ {
+		tr, err := newTrash(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, tr.Dir)
+	}
+	if dirs[0] == dirs[1] || dirs[1] == dirs[2] || dirs[0] == dirs[2] {
+		t.Errorf("want a folder per run, have %v", dirs)
+	}
+	runs, err := trashRuns(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, run := range runs {
+		if filepath.Join(trashPath(out), run) != dirs[i] {
+			t.Errorf("want runs in the order they started, have %v", runs)
+			break
+		}
+	}
+}
+
+func TestTrashRemoveAll(t *testing.T) {
+	out := t.TempDir()
+	folder := filepath.Join(out, "repo")
+	writeTestFile(t, filepath.Join(folder, "main.go"), "package main\n")
+	writeTestFile(t, filepath.Join(folder, ".git", "HEAD"), "ref: refs/heads/main\n")
+	tr, err := newTrash(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := StepParams{Trash: tr}
+	if err := removeAll(p, folder, "interrupted"); err != nil {
+		t.Fatal(err)
+	}
+	if fsExists(folder) {
+		t.Error("want the folder removed")
+	}
+	entries, err := readTrash(tr.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "repo/main.go" || entries[0].Rule != "interrupted" {
+		t.Errorf("want only repo/main.go quarantined, have %v", entries)
+	}
+	restored, errs, err := restoreTrash(out, filepath.Base(tr.Dir))
+	if err != nil || len(errs) > 0 || restored != 1 {
+		t.Errorf("want 1 file restored, have %v %v %v", restored, errs, err)
+	}
+}
+
+func TestTrashCloseEmpty(t *testing.T) {
+	out := t.TempDir()
+	tr, err := newTrash(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Close()
+	if fsExists(tr.Dir) {
+		t.Error("want an empty run removed")
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Vcs is the version control backend used to acquire repos.
+type Vcs interface {
+	// Clone clones the remote into the folder.
+	Clone(remote, folder string) error
+	// Fetch creates the folder with only the commit for the
+	// fetch ref, at depth 1, without checking it out.
+	Fetch(remote, folder string, fetch VcsFetch) error
+	// Mirror creates or updates a bare mirror of the remote.
+	Mirror(remote, dir string) error
+	// Export clones the local mirror into the folder, checking
+	// out its default branch, with the remote as its origin.
+	Export(dir, folder, remote string) error
+	// Checkout checks out the ref, which can be a branch, tag or
+	// commit, in the folder. If there are sparse folders only
+	// those, and the files at the root, are checked out.
+	Checkout(folder, ref string, sparse []string) error
+	// Head answers the commit checked out in the folder.
+	Head(folder string) (string, error)
+	// Origin answers the url of the folder's origin remote.
+	Origin(folder string) (string, error)
+	// Submodules answers the submodules of the checked out commit.
+	Submodules(folder string) ([]VcsSubmodule, error)
+	// Show answers the contents of the file, a slash path from the
+	// repo root, at the ref, without checking anything out. A file
+	// that isn't in the ref is an errVcsNoFile error.
+	Show(folder, ref, file string) ([]byte, error)
+}
+
+// newVcs answers the backend for the name, which is one of
+// the Vcs consts. Empty is the in-process git.
+func newVcs(name string) (Vcs, error) {
+	switch strings.ToLower(name) {
+	case "", VcsNative:
+		return nativeVcs{}, nil
+	case VcsExec:
+		return execVcs{}, nil
+	}
+	return nil, fmt.Errorf("unknown vcs %v", name)
+}
+
+// vcs answers the configured backend.
+func (p StepParams) vcs() Vcs {
+	v, err := newVcs(p.Cfg.Vcs)
+	if err != nil {
+		// The config is validated when loaded.
+		return nativeVcs{}
+	}
+	return v
+}
+
+// VcsFetch describes a shallow fetch.
+type VcsFetch struct {
+	Ref    string // The ref to fetch, in the checkout form i.e. "tags/v1.2.0" or a full commit SHA
+	Filter string // A partial clone filter i.e. "blob:none", for backends that support it
+}
+
+// refSpec answers the git refspec for the fetch ref. Short
+// SHAs can't be fetched, since servers only match full ones.
+func (f VcsFetch) refSpec() (string, error) {
+	switch {
+	case strings.HasPrefix(f.Ref, "tags/"):
+		ref := "refs/" + f.Ref
+		return "+" + ref + ":" + ref, nil
+	case vcsIsSha(f.Ref):
+		return f.Ref, nil
+	case len(f.Ref) > 0 && vcsIsHex(f.Ref):
+		return "", &VcsError{Op: "fetch", Target: f.Ref, Kind: errVcsShallow}
+	}
+	return "+refs/heads/" + f.Ref + ":refs/remotes/origin/" + f.Ref, nil
+}
+
+// vcsIsSha answers true if the ref is a full commit SHA.
+func vcsIsSha(ref string) bool {
+	return len(ref) == 40 && vcsIsHex(ref)
+}
+
+func vcsIsHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// VcsError is a failed VCS operation. Kind is one of the
+// errVcs errors if the failure was recognized, and can be
+// tested with errors.Is.
+type VcsError struct {
+	Op       string // clone, fetch, mirror, export, checkout or show
+	Target   string // The remote or ref
+	Kind     error
+	Redirect string // The remote to use instead, for errVcsRedirect
+	Err      error
+}
+
+func (e *VcsError) Error() string {
+	msg := e.Op + " " + e.Target
+	if e.Kind != nil {
+		msg += ": " + e.Kind.Error()
+	}
+	if e.Redirect != "" {
+		msg += " to " + e.Redirect
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *VcsError) Unwrap() error {
+	return e.Err
+}
+
+func (e *VcsError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// vcsRedirect answers the remote a failed clone says to use
+// instead, if any.
+func vcsRedirect(err error) string {
+	var ve *VcsError
+	if errors.As(err, &ve) && ve.Kind == errVcsRedirect {
+		return ve.Redirect
+	}
+	return ""
+}
+
+// vcsClassify answers the errVcs kind for a git message,
+// and the redirect target, if any. Both git and go-git
+// messages are recognized.
+func vcsClassify(msg string) (error, string) {
+	lower := strings.ToLower(msg)
+	if r := vcsRedirectMessage(msg); r != "" {
+		return errVcsRedirect, r
+	}
+	switch {
+	case strings.Contains(lower, "ssh: connect to host"),
+		strings.Contains(lower, "could not resolve host"),
+		strings.Contains(lower, "could not resolve hostname"),
+		strings.Contains(lower, "no such host"),
+		strings.Contains(lower, "connection refused"):
+		return errVcsUnreachable, ""
+	case strings.Contains(lower, "authentication"),
+		strings.Contains(lower, "permission denied"),
+		strings.Contains(lower, "could not read username"),
+		strings.Contains(lower, "terminal prompts disabled"),
+		strings.Contains(lower, "ssh_auth_sock"),
+		strings.Contains(lower, "unable to authenticate"),
+		strings.Contains(lower, "authorization failed"),
+		strings.Contains(lower, "host key"):
+		return errVcsAuth, ""
+	case strings.Contains(lower, "did not match any file(s) known to git"),
+		strings.Contains(lower, "reference not found"),
+		strings.Contains(lower, "unknown revision"),
+		strings.Contains(lower, "invalid reference"):
+		return errVcsMissingRef, ""
+	case strings.Contains(lower, "repository not found"),
+		strings.Contains(lower, "does not appear to be a git repository"),
+		strings.Contains(lower, "does not exist"),
+		strings.Contains(lower, "not found"):
+		return errVcsNotFound, ""
+	}
+	return nil, ""
+}
+
+// vcsRedirectMessage answers the remote in a "remote: Use 'git
+// clone <remote>' instead" message.
+func vcsRedirectMessage(str string) string {
+	pre := `remote: Use 'git clone `
+	suf := `' instead`
+	pi := strings.Index(str, pre)
+	if pi < 0 {
+		return ""
+	}
+	si := strings.Index(str[pi:], suf)
+	if si < 0 {
+		return ""
+	}
+	return str[pi+len(pre) : pi+si]
+}
+
+// execVcs runs the git binary.
+type execVcs struct {
+}
+
+func (v execVcs) Clone(remote, folder string) error {
+	_, err := v.git("", "clone", remote, folder)
+	return v.wrap("clone", remote, err)
+}
+
+func (v execVcs) Fetch(remote, folder string, fetch VcsFetch) error {
+	spec, err := fetch.refSpec()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+	args := []string{"fetch", "--depth", "1", "--no-tags"}
+	if fetch.Filter != "" {
+		args = append(args, "--filter="+fetch.Filter)
+	}
+	args = append(args, "origin", spec)
+	_, err = v.git(folder, "init", "-q")
+	if err == nil {
+		_, err = v.git(folder, "remote", "add", "origin", remote)
+	}
+	if err == nil {
+		_, err = v.git(folder, args...)
+	}
+	return v.wrap("fetch", remote+" "+fetch.Ref, err)
+}
+
+func (v execVcs) Mirror(remote, dir string) error {
+	if fsExists(dir) {
+		_, err := v.git(dir, "remote", "update", "--prune")
+		return v.wrap("mirror", remote, err)
+	}
+	// Clone beside the final folder so an interrupted clone
+	// never looks like a mirror.
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp := dir + ".tmp"
+	os.RemoveAll(tmp)
+	_, err := v.git("", "clone", "--mirror", remote, tmp)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return v.wrap("mirror", remote, err)
+	}
+	return os.Rename(tmp, dir)
+}
+
+func (v execVcs) Export(dir, folder, remote string) error {
+	_, err := v.git("", "clone", dir, folder)
+	if err == nil {
+		_, err = v.git(folder, "remote", "set-url", "origin", remote)
+	}
+	return v.wrap("export", dir, err)
+}
+
+func (v execVcs) Checkout(folder, ref string, sparse []string) error {
+	if len(sparse) > 0 {
+		args := append([]string{"sparse-checkout", "set", "--cone", "--"}, sparse...)
+		if _, err := v.git(folder, args...); err != nil {
+			return v.wrap("checkout", ref, err)
+		}
+	}
+	_, err := v.git(folder, "checkout", ref)
+	if err != nil {
+		// A fetched branch only exists as a remote branch.
+		if _, rerr := v.git(folder, "checkout", "origin/"+ref); rerr == nil {
+			return nil
+		}
+	}
+	return v.wrap("checkout", ref, err)
+}
+
+func (v execVcs) Head(folder string) (string, error) {
+	out, err := v.git(folder, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (v execVcs) Origin(folder string) (string, error) {
+	out, err := v.git(folder, "remote", "get-url", "origin")
+	return strings.TrimSpace(out), err
+}
+
+func (v execVcs) Submodules(folder string) ([]VcsSubmodule, error) {
+	origin, _ := v.Origin(folder)
+	return vcsSubmodules(folder, origin, func(p string) (string, error) {
+		// i.e. "160000 commit <sha>\t<path>"
+		out, err := v.git(folder, "ls-tree", "HEAD", "--", p)
+		if err != nil {
+			return "", err
+		}
+		fields := strings.Fields(out)
+		if len(fields) < 3 || fields[1] != "commit" {
+			return "", nil
+		}
+		return fields[2], nil
+	})
+}
+
+func (v execVcs) Show(folder, ref, file string) ([]byte, error) {
+	commit := ref
+	if _, err := v.git(folder, "rev-parse", "--verify", "-q", commit+"^{commit}"); err != nil {
+		// A fetched branch only exists as a remote branch.
+		commit = "origin/" + ref
+		if _, rerr := v.git(folder, "rev-parse", "--verify", "-q", commit+"^{commit}"); rerr != nil {
+			return nil, &VcsError{Op: "show", Target: ref, Kind: errVcsMissingRef, Err: err}
+		}
+	}
+	if _, err := v.git(folder, "cat-file", "-e", commit+":"+file); err != nil {
+		return nil, &VcsError{Op: "show", Target: ref + ":" + file, Kind: errVcsNoFile, Err: err}
+	}
+	out, err := v.git(folder, "show", commit+":"+file)
+	return []byte(out), v.wrap("show", ref+":"+file, err)
+}
+
+// git runs a git command. Prompts are disabled and messages
+// are forced to English, so failures can be recognized.
+func (v execVcs) git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "LANG=C", "GIT_TERMINAL_PROMPT=0", "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return string(out), wrapErr(err, string(out)+" "+stderr.String())
+	}
+	return string(out), nil
+}
+
+func (v execVcs) wrap(op, target string, err error) error {
+	if err == nil {
+		return nil
+	}
+	kind, redirect := vcsClassify(err.Error())
+	return &VcsError{Op: op, Target: target, Kind: kind, Redirect: redirect, Err: err}
+}
+
+// ------------------------------------------------------------
+// CONST and VAR
+
+const (
+	VcsNative = "native" // In-process git
+	VcsExec   = "exec"   // The git binary
+)
+
+var (
+	errVcsAuth        = fmt.Errorf("authentication failed")
+	errVcsNotFound    = fmt.Errorf("repository not found")
+	errVcsRedirect    = fmt.Errorf("repository moved")
+	errVcsMissingRef  = fmt.Errorf("missing ref")
+	errVcsUnreachable = fmt.Errorf("host unreachable")
+	errVcsShallow     = fmt.Errorf("can't fetch shallow")
+	errVcsNoFile      = fmt.Errorf("no such file")
+)
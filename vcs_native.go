@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// nativeVcs is an in-process git, so it doesn't depend on
+// the git binary or its messages.
+type nativeVcs struct {
+}
+
+func init() {
+	// go-git runs git-upload-pack for local repos unless it's
+	// told to serve them itself.
+	client.InstallProtocol("file", server.NewServer(nativeLoader{}))
+}
+
+// nativeLoader serves local repos, which go-git's loader only
+// does for bare ones.
+type nativeLoader struct {
+}
+
+func (l nativeLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	sto, err := server.DefaultLoader.Load(ep)
+	if err != transport.ErrRepositoryNotFound {
+		return sto, err
+	}
+	dot := *ep
+	dot.Path = path.Join(ep.Path, ".git")
+	return server.DefaultLoader.Load(&dot)
+}
+
+func (v nativeVcs) Clone(remote, folder string) error {
+	_, err := git.PlainClone(folder, false, &git.CloneOptions{URL: remote})
+	return v.wrap("clone", remote, err)
+}
+
+func (v nativeVcs) Fetch(remote, folder string, fetch VcsFetch) error {
+	spec, err := fetch.refSpec()
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(spec, ":") {
+		// Fetched commits need somewhere to live.
+		spec += ":refs/guzzle/fetch"
+	}
+	repo, err := git.PlainInit(folder, false)
+	if err != nil {
+		return v.wrap("fetch", remote+" "+fetch.Ref, err)
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+	if err != nil {
+		return v.wrap("fetch", remote+" "+fetch.Ref, err)
+	}
+	// Partial clones aren't supported, so the filter is ignored,
+	// and local repos are served without shallow support.
+	depth := 1
+	if ep, err := transport.NewEndpoint(remote); err == nil && ep.Protocol == "file" {
+		depth = 0
+	}
+	err = repo.Fetch(&git.FetchOptions{RefSpecs: []config.RefSpec{config.RefSpec(spec)}, Depth: depth, Tags: git.NoTags})
+	return v.wrap("fetch", remote+" "+fetch.Ref, err)
+}
+
+func (v nativeVcs) Mirror(remote, dir string) error {
+	if fsExists(dir) {
+		repo, err := git.PlainOpen(dir)
+		if err != nil {
+			return v.wrap("mirror", remote, err)
+		}
+		err = repo.Fetch(&git.FetchOptions{RefSpecs: []config.RefSpec{"+refs/*:refs/*"}, Force: true})
+		if err == git.NoErrAlreadyUpToDate {
+			err = nil
+		}
+		return v.wrap("mirror", remote, err)
+	}
+	// Clone beside the final folder so an interrupted clone
+	// never looks like a mirror.
+	tmp := dir + ".tmp"
+	os.RemoveAll(tmp)
+	_, err := git.PlainClone(tmp, true, &git.CloneOptions{URL: remote, Mirror: true})
+	if err != nil {
+		os.RemoveAll(tmp)
+		return v.wrap("mirror", remote, err)
+	}
+	return os.Rename(tmp, dir)
+}
+
+func (v nativeVcs) Export(dir, folder, remote string) error {
+	repo, err := git.PlainClone(folder, false, &git.CloneOptions{URL: dir, Tags: git.AllTags})
+	if err != nil {
+		return v.wrap("export", dir, err)
+	}
+	cfg, err := repo.Config()
+	if err == nil {
+		cfg.Remotes["origin"].URLs = []string{remote}
+		err = repo.SetConfig(cfg)
+	}
+	return v.wrap("export", dir, err)
+}
+
+func (v nativeVcs) Checkout(folder, ref string, sparse []string) error {
+	repo, err := git.PlainOpen(folder)
+	if err != nil {
+		return v.wrap("checkout", ref, err)
+	}
+	hash, err := v.resolve(repo, ref)
+	if err != nil {
+		return v.wrap("checkout", ref, err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return v.wrap("checkout", ref, err)
+	}
+	err = wt.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
+	if err == nil && len(sparse) > 0 {
+		// go-git checks out every file, even with sparse
+		// directories, so remove what git's cone mode wouldn't
+		// have checked out.
+		err = vcsSparsePrune(folder, "", sparse)
+	}
+	return v.wrap("checkout", ref, err)
+}
+
+// vcsSparsePrune removes the folders under dir that git's
+// cone mode would leave out for the sparse folders. Files
+// in the root and in the parents of sparse folders stay.
+func vcsSparsePrune(root, dir string, sparse []string) error {
+	entries, err := os.ReadDir(filepath.Join(root, dir))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || (dir == "" && e.Name() == ".git") {
+			continue
+		}
+		name := path.Join(dir, e.Name())
+		keep, parent := false, false
+		for _, s := range sparse {
+			s = path.Clean(s)
+			keep = keep || s == name || strings.HasPrefix(name, s+"/")
+			parent = parent || strings.HasPrefix(s, name+"/")
+		}
+		switch {
+		case keep:
+		case parent:
+			err = vcsSparsePrune(root, name, sparse)
+		default:
+			err = os.RemoveAll(filepath.Join(root, filepath.FromSlash(name)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v nativeVcs) Head(folder string) (string, error) {
+	repo, err := git.PlainOpen(folder)
+	if err != nil {
+		return "", err
+	}
+	ref, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
+
+func (v nativeVcs) Origin(folder string) (string, error) {
+	repo, err := git.PlainOpen(folder)
+	if err != nil {
+		return "", err
+	}
+	return v.origin(repo)
+}
+
+func (v nativeVcs) origin(repo *git.Repository) (string, error) {
+	r, err := repo.Remote("origin")
+	if err != nil {
+		return "", err
+	}
+	if len(r.Config().URLs) < 1 {
+		return "", fmt.Errorf("origin has no url")
+	}
+	return r.Config().URLs[0], nil
+}
+
+func (v nativeVcs) Submodules(folder string) ([]VcsSubmodule, error) {
+	repo, err := git.PlainOpen(folder)
+	if err != nil {
+		return nil, err
+	}
+	origin, _ := v.origin(repo)
+	ref, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return vcsSubmodules(folder, origin, func(p string) (string, error) {
+		e, err := tree.FindEntry(p)
+		if err != nil || e.Mode != filemode.Submodule {
+			return "", nil
+		}
+		return e.Hash.String(), nil
+	})
+}
+
+func (v nativeVcs) Show(folder, ref, file string) ([]byte, error) {
+	repo, err := git.PlainOpen(folder)
+	if err != nil {
+		return nil, v.wrap("show", ref, err)
+	}
+	hash, err := v.resolve(repo, ref)
+	var ve *VcsError
+	if errors.As(err, &ve) {
+		ve.Op = "show"
+	}
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, v.wrap("show", ref, err)
+	}
+	f, err := commit.File(file)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, &VcsError{Op: "show", Target: ref + ":" + file, Kind: errVcsNoFile, Err: err}
+	} else if err != nil {
+		return nil, v.wrap("show", ref+":"+file, err)
+	}
+	s, err := f.Contents()
+	return []byte(s), v.wrap("show", ref+":"+file, err)
+}
+
+// resolve answers the commit for the ref. Branches only exist
+// as remote branches after a clone, so those are tried too.
+func (v nativeVcs) resolve(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	if vcsIsSha(ref) {
+		// Shallow fetches can't resolve a SHA through refs.
+		if _, err := repo.CommitObject(plumbing.NewHash(ref)); err == nil {
+			return plumbing.NewHash(ref), nil
+		}
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err == nil {
+		return *hash, nil
+	}
+	if h, rerr := repo.ResolveRevision(plumbing.Revision("origin/" + ref)); rerr == nil {
+		return *h, nil
+	}
+	return plumbing.ZeroHash, &VcsError{Op: "checkout", Target: ref, Kind: errVcsMissingRef, Err: err}
+}
+
+func (v nativeVcs) wrap(op, target string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var ve *VcsError
+	if errors.As(err, &ve) {
+		return err
+	}
+	var kind error
+	redirect := ""
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired),
+		errors.Is(err, transport.ErrAuthorizationFailed),
+		errors.Is(err, transport.ErrInvalidAuthMethod):
+		kind = errVcsAuth
+	case errors.Is(err, transport.ErrRepositoryNotFound),
+		errors.Is(err, transport.ErrEmptyRemoteRepository):
+		kind = errVcsNotFound
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		kind = errVcsMissingRef
+	default:
+		// Transport errors that aren't typed, i.e. ssh and dns.
+		kind, redirect = vcsClassify(err.Error())
+	}
+	return &VcsError{Op: op, Target: target, Kind: kind, Redirect: redirect, Err: err}
+}
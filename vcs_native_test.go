@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// writeTestGitRepo writes a repo with a go.mod at a v1.0.0 tag,
+// and a second commit on the default branch, without the git binary.
+func writeTestGitRepo(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "a", Email: "a@b", When: time.Now()}
+	commit := func(file, content string) string {
+		writeTestFile(t, filepath.Join(dir, file), content)
+		if _, err := wt.Add(file); err != nil {
+			t.Fatal(err)
+		}
+		h, err := wt.Commit("add "+file, &git.CommitOptions{Author: sig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.String()
+	}
+	tagged := commit("go.mod", "module example.com/a\n")
+	if _, err = repo.CreateTag("v1.0.0", plumbing.NewHash(tagged), nil); err != nil {
+		t.Fatal(err)
+	}
+	commit("a.go", "package a\n")
+	return dir, tagged
+}
+
+func TestNativeVcsIsDefault(t *testing.T) {
+	v, err := newVcs("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v.(nativeVcs); !ok {
+		t.Errorf("want the native backend by default, have %T", v)
+	}
+}
+
+func TestNativeVcsCloneCheckout(t *testing.T) {
+	remote, tagged := writeTestGitRepo(t)
+	v := (StepParams{}).vcs()
+	folder := filepath.Join(t.TempDir(), "a")
+	if err := v.Clone(remote, folder); err != nil {
+		t.Fatal(err)
+	}
+	if origin, err := v.Origin(folder); err != nil || origin != remote {
+		t.Errorf("want origin %v, have %v %v", remote, origin, err)
+	}
+	if err := v.Checkout(folder, "tags/v1.0.0", nil); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := v.Head(folder); err != nil || head != tagged {
+		t.Errorf("want head %v, have %v %v", tagged, head, err)
+	}
+	if err := v.Checkout(folder, "tags/v9.9.9", nil); !errors.Is(err, errVcsMissingRef) {
+		t.Errorf("want a missing ref, have %v", err)
+	}
+	if b, err := v.Show(folder, "tags/v1.0.0", "go.mod"); err != nil || string(b) != "module example.com/a\n" {
+		t.Errorf("want the go.mod, have %q %v", b, err)
+	}
+	if _, err := v.Show(folder, "tags/v1.0.0", "a.go"); !errors.Is(err, errVcsNoFile) {
+		t.Errorf("want no file, have %v", err)
+	}
+}
+
+func TestNativeVcsFetchAndExport(t *testing.T) {
+	remote, tagged := writeTestGitRepo(t)
+	v := nativeVcs{}
+	fetched := filepath.Join(t.TempDir(), "fetched")
+	if err := v.Fetch(remote, fetched, VcsFetch{Ref: "tags/v1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Checkout(fetched, "tags/v1.0.0", nil); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := v.Head(fetched); err != nil || head != tagged {
+		t.Errorf("want head %v, have %v %v", tagged, head, err)
+	}
+	mirror := filepath.Join(t.TempDir(), "a.git")
+	if err := v.Mirror(remote, mirror); err != nil {
+		t.Fatal(err)
+	}
+	exported := filepath.Join(t.TempDir(), "exported")
+	if err := v.Export(mirror, exported, remote); err != nil {
+		t.Fatal(err)
+	}
+	if origin, err := v.Origin(exported); err != nil || origin != remote {
+		t.Errorf("want origin %v, have %v %v", remote, origin, err)
+	}
+	if fsNotExists(filepath.Join(exported, "a.go")) {
+		t.Error("want the default branch checked out")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+)
+
+// VcsSubmodule is a submodule of a repo, pinned to a commit.
+type VcsSubmodule struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`   // Relative to the repo, with forward slashes
+	Url    string `json:"url"`    // Relative urls are resolved against the repo's origin
+	Commit string `json:"commit"` // The commit the repo pins
+}
+
+// vcsSubmodules answers the submodules declared in the folder's
+// .gitmodules, using gitlink to find the commit each is pinned
+// to and origin as the base for relative urls.
+func vcsSubmodules(folder, origin string, gitlink func(string) (string, error)) ([]VcsSubmodule, error) {
+	b, err := os.ReadFile(filepath.Join(folder, ".gitmodules"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	mods := config.NewModules()
+	if err = mods.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	var subs []VcsSubmodule
+	for _, m := range mods.Submodules {
+		commit, err := gitlink(m.Path)
+		if err != nil {
+			return nil, err
+		}
+		if commit == "" {
+			// Declared but not in the tree, which git ignores too.
+			continue
+		}
+		u := vcsResolveUrl(origin, m.URL)
+		subs = append(subs, VcsSubmodule{Name: m.Name, Path: path.Clean(m.Path), Url: u, Commit: commit})
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Path < subs[j].Path
+	})
+	return subs, nil
+}
+
+// vcsResolveUrl resolves a submodule url, which can be relative
+// to the origin of its repo.
+func vcsResolveUrl(origin, ref string) string {
+	if !strings.HasPrefix(ref, "./") && !strings.HasPrefix(ref, "../") {
+		return ref
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(origin, "/"), ".git")
+	if u, err := url.Parse(base); err == nil && u.Scheme != "" && u.Host != "" {
+		u.Path = path.Join(u.Path, ref)
+		return u.String()
+	}
+	// scp-like git@host:path
+	if i := strings.Index(base, ":"); i > 0 && !strings.Contains(base[:i], "/") {
+		return base[:i+1] + strings.TrimPrefix(path.Join("/", base[i+1:], ref), "/")
+	}
+	if strings.HasPrefix(base, "file://") {
+		return "file://" + path.Join(strings.TrimPrefix(base, "file://"), ref)
+	}
+	return filepath.Join(base, filepath.FromSlash(ref))
+}
+
+// vcsRepoName answers the repo name for a remote url, i.e.
+// "github.com/a/b" for "https://github.com/a/b.git", so it can be
+// redirected and cloned like any other repo. Local remotes are
+// answered unchanged.
+func vcsRepoName(remote string) string {
+	if strings.HasPrefix(remote, "file://") || filepath.IsAbs(remote) {
+		return remote
+	}
+	name := remote
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" && u.Host != "" {
+		name = u.Hostname() + u.Path
+	} else if i := strings.Index(remote, ":"); i > 0 && !strings.Contains(remote[:i], "/") {
+		host := remote[:i]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		name = host + "/" + strings.TrimPrefix(remote[i+1:], "/")
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(name, "/"), ".git")
+}